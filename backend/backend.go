@@ -6,17 +6,28 @@ import (
 	"errors"
 	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/logical"
+	"sync"
 	"time"
 )
 
 type Path string
 
 const (
-	PathCluster  Path = "config/cluster/%s"
-	PathDatabase Path = "config/cluster/%s/database/%s"
-	PathRole     Path = "config/role/%s"
-	PathMeta     Path = "meta/%s"
+	PathConfig        Path = "config/mount"
+	PathCluster       Path = "config/cluster/%s"
+	PathDatabase      Path = "config/cluster/%s/database/%s"
+	PathRole          Path = "config/role/%s"
+	PathRoleVersion   Path = "config/role-version/%s/%s"
+	PathMeta          Path = "meta/%s"
+	PathMetaSchema    Path = "config/metadata-schema/%s"
+	PathAlias         Path = "config/alias/%s"
+	PathLease         Path = "lease/%s/%s"
+	PathEntityUsers   Path = "entity-user/"
+	PathEntityUser    Path = "entity-user/%s/%s"
+	PathDisabledUsers Path = "disabled-user/"
+	PathDisabledUser  Path = "disabled-user/%s/%s"
 )
 
 const (
@@ -26,10 +37,29 @@ const (
 	queryUpdatePassword         = `alter user {{user}} with password '{{password}}'`
 	queryCreateManagementRole   = `create role {{user}} with login password '{{password}}' createrole nocreatedb noinherit`
 	queryRenewExpiry            = `alter role {{user}} valid until '{{expiration}}'`
+	queryDropDb                 = `drop database if exists {{database}}`
+	queryRevokePublicConnect    = `revoke connect on database {{database}} from public`
+	queryGrantConnect           = `grant connect on database {{database}} to {{role_name}}`
+	queryGrantConnectManagement = `grant connect on database {{database}} to {{role_name}} with grant option`
+	queryDropOwned              = `drop owned by {{role_name}}`
+	queryDropRole               = `drop role if exists {{role_name}}`
+	queryDbExists               = `select exists (select 1 from pg_database where datname = $1)`
+	queryRoleExists             = `select exists (select 1 from pg_roles where rolname = $1)`
+	queryUserCanLogin           = `select rolcanlogin from pg_roles where rolname = $1`
+	queryUserCanConnect         = `select has_database_privilege($1, $2, 'CONNECT')`
+	queryAssumeObjectsOwner     = `alter role {{user}} in database {{database}} set role to {{objects_owner}}`
+	queryUserHasRole            = `select pg_has_role($1, $2, 'member')`
+	queryDisableLogin           = `alter role {{user}} with nologin`
+	queryGrantObjectsOwner      = `grant {{objects_owner}} to {{user}}`
+	queryUserMemberships        = `select r.rolname from pg_auth_members m join pg_roles r on r.oid = m.roleid join pg_roles u on u.oid = m.member where u.rolname = $1`
+	queryGrantGroup             = `grant %s to {{user}}`
+	queryRevokeGroup            = `revoke %s from {{user}}`
 )
 
 const SecretCredsType = "creds"
 
+const walTypeDatabase = "database"
+
 var ErrNotFound = errors.New("requested record was not found")
 
 func (p Path) For(n ...interface{}) string {
@@ -38,6 +68,19 @@ func (p Path) For(n ...interface{}) string {
 
 type backend struct {
 	*framework.Backend
+
+	// entityUsersLock serializes the updates to persistent users of entities
+	entityUsersLock sync.Mutex
+
+	// entityLoginLock serializes the issue of credentials for persistent users
+	// of entities, so that a user is only created by the first issue
+	entityLoginLock sync.Mutex
+
+	// metadataLock serializes the updates to metadata for check-and-set
+	metadataLock sync.Mutex
+
+	// aliasLock serializes the updates to cluster aliases
+	aliasLock sync.Mutex
 }
 
 func Factory(ctx context.Context, c *logical.BackendConfig) (logical.Backend, error) {
@@ -82,6 +125,29 @@ func New(c *logical.BackendConfig) *backend {
 				HelpSynopsis:    helpSynopsisInfo,
 				HelpDescription: helpDescriptionInfo,
 			},
+			{
+				Pattern: "config",
+				Fields: map[string]*framework.FieldSchema{
+					"root_execution_roles": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Names of the roles that are allowed to execute statements using the root connection",
+					},
+					"client_ca_certificate": {
+						Type:        framework.TypeString,
+						Description: "PEM encoded CA certificate that signs the client certificates issued by roles with credential_type 'client_certificate'",
+					},
+					"client_ca_private_key": {
+						Type:        framework.TypeString,
+						Description: "PEM encoded private key of the client CA",
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ReadOperation:   NewOperationHandler(b.pathConfigRead, propsConfigRead),
+					logical.UpdateOperation: NewOperationHandler(b.pathConfigUpdate, propsConfigUpdate),
+				},
+				HelpSynopsis:    helpSynopsisConfig,
+				HelpDescription: helpDescriptionConfig,
+			},
 			{
 				Pattern: "metadata/?$",
 				Fields: map[string]*framework.FieldSchema{
@@ -101,6 +167,29 @@ func New(c *logical.BackendConfig) *backend {
 						Type:        framework.TypeKVPairs,
 						Description: "key-value pairs to associate with the object",
 					},
+					"mode": {
+						Type:          framework.TypeString,
+						Description:   "Mode of the update. 'replace' replaces all metadata of the object with 'data', 'patch' only sets the keys in 'data' and removes the keys in 'remove_keys'",
+						Default:       metadataModeReplace,
+						AllowedValues: []interface{}{metadataModeReplace, metadataModePatch},
+					},
+					"remove_keys": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Keys to remove from the metadata of the object. Only supported in 'patch' mode",
+					},
+					"cas": {
+						Type:        framework.TypeInt,
+						Description: "Check-and-set parameter. If set the update is only applied if it matches the current version of the metadata, 0 means that the metadata must not exist",
+					},
+					"selector": {
+						Type:        framework.TypeString,
+						Description: "Selector expression to match the metadata of objects on lookup, for example 'env!=prod, team in (payments, search)'",
+					},
+					"include_deleted": {
+						Type:        framework.TypeBool,
+						Description: "If set to true the lookup also matches the metadata of deleted objects",
+						Default:     false,
+					},
 				},
 				Operations: map[logical.Operation]framework.OperationHandler{
 					logical.UpdateOperation: NewOperationHandler(b.pathMetadataUpdate, propsMetadataUpdate),
@@ -110,6 +199,44 @@ func New(c *logical.BackendConfig) *backend {
 				HelpSynopsis:    helpSynopsisMetadata,
 				HelpDescription: helpDescriptionMetadata,
 			},
+			{
+				Pattern: "metadata/check$",
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ReadOperation:   NewOperationHandler(b.pathMetadataCheckRead, propsMetadataCheckRead),
+					logical.UpdateOperation: NewOperationHandler(b.pathMetadataCheckUpdate, propsMetadataCheckUpdate),
+				},
+				HelpSynopsis:    helpSynopsisMetadataCheck,
+				HelpDescription: helpDescriptionMetadataCheck,
+			},
+			{
+				Pattern: "metadata-schema/(?P<type>cluster|database)$",
+				Fields: map[string]*framework.FieldSchema{
+					"type": {
+						Type:        framework.TypeString,
+						Description: "Type of the object the schema applies to. Must be one of 'cluster' or 'database'",
+					},
+					"required_keys": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Keys that must be present in the metadata of every object",
+					},
+					"keys": {
+						Type:        framework.TypeMap,
+						Description: "Map from key to its constraints, which are 'allowed_values', 'pattern' and 'immutable'",
+					},
+					"allow_unknown_keys": {
+						Type:        framework.TypeBool,
+						Description: "If set to false the metadata can only contain the keys declared in 'keys'",
+						Default:     true,
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ReadOperation:   NewOperationHandler(b.pathMetadataSchemaRead, propsMetadataSchemaRead),
+					logical.UpdateOperation: NewOperationHandler(b.pathMetadataSchemaUpdate, propsMetadataSchemaUpdate),
+					logical.DeleteOperation: NewOperationHandler(b.pathMetadataSchemaDelete, propsMetadataSchemaDelete),
+				},
+				HelpSynopsis:    helpSynopsisMetadataSchema,
+				HelpDescription: helpDescriptionMetadataSchema,
+			},
 			{
 				Pattern: "metadata/(?P<id>.+)",
 				Fields: map[string]*framework.FieldSchema{
@@ -117,12 +244,71 @@ func New(c *logical.BackendConfig) *backend {
 						Type:        framework.TypeString,
 						Description: "Metadata ID",
 					},
+					"cas": {
+						Type:        framework.TypeInt,
+						Description: "Check-and-set parameter. If set the metadata is only deleted if it matches the current version of the metadata",
+					},
 				},
 				Operations: map[logical.Operation]framework.OperationHandler{
 					logical.DeleteOperation: NewOperationHandler(b.pathMetadataDelete, propsMetadataDelete),
 				},
 				HelpSynopsis: "Delete metadata using ID",
 			},
+			{
+				Pattern: "alias/?$",
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ListOperation: NewOperationHandler(b.pathAliasesList, propsAliasesList),
+				},
+				HelpSynopsis:    helpSynopsisAlias,
+				HelpDescription: helpDescriptionAlias,
+			},
+			{
+				Pattern: "alias/" + framework.GenericNameRegex("alias") + "/cutover$",
+				Fields: map[string]*framework.FieldSchema{
+					"alias": {
+						Type:        framework.TypeString,
+						Description: "Name of the alias",
+					},
+					"target": {
+						Type:        framework.TypeString,
+						Description: "Name of the cluster that the alias is repointed to",
+					},
+					"source": {
+						Type:        framework.TypeString,
+						Description: "Name of the cluster that the alias is expected to point to. The cutover fails if the alias points to another cluster",
+					},
+					"delete_source": {
+						Type:        framework.TypeBool,
+						Default:     false,
+						Description: "If set to true the cluster that the alias pointed to is marked as deleted after the cutover",
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: NewOperationHandler(b.pathAliasCutover, propsAliasCutover),
+				},
+				HelpSynopsis:    helpSynopsisAliasCutover,
+				HelpDescription: helpDescriptionAliasCutover,
+			},
+			{
+				Pattern: "alias/" + framework.GenericNameRegex("alias") + "/?$",
+				Fields: map[string]*framework.FieldSchema{
+					"alias": {
+						Type:        framework.TypeString,
+						Description: "Name of the alias",
+					},
+					"cluster": {
+						Type:        framework.TypeString,
+						Description: "Name of the registered cluster that the alias points to",
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ReadOperation:   NewOperationHandler(b.pathAliasRead, propsAliasRead),
+					logical.UpdateOperation: NewOperationHandler(b.pathAliasUpdate, propsAliasUpdate),
+					logical.DeleteOperation: NewOperationHandler(b.pathAliasDelete, propsAliasDelete),
+				},
+				HelpSynopsis:    helpSynopsisAlias,
+				HelpDescription: helpDescriptionAlias,
+			},
 			{
 				Pattern: "cluster/?$",
 				Operations: map[logical.Operation]framework.OperationHandler{
@@ -180,6 +366,18 @@ func New(c *logical.BackendConfig) *backend {
 						Description: "Whether or not to use SSL",
 						Default:     "require",
 					},
+					"max_credential_ttl": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Upper limit for the TTL of credentials issued in this cluster. Zero means no limit",
+					},
+					"client_ca_certificate": {
+						Type:        framework.TypeString,
+						Description: "PEM encoded CA certificate that signs the client certificates issued for this cluster. Overrides the CA configured on the mount",
+					},
+					"client_ca_private_key": {
+						Type:        framework.TypeString,
+						Description: "PEM encoded private key of the client CA",
+					},
 				},
 				Operations: map[logical.Operation]framework.OperationHandler{
 					logical.ReadOperation:   NewOperationHandler(b.pathClusterRead, propsClusterRead),
@@ -215,6 +413,36 @@ func New(c *logical.BackendConfig) *backend {
 						Default:     false,
 						Description: "If set to true the clone will inherit the configuration for deleted databases as well",
 					},
+					"include": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Names of the databases to inherit. Supports a leading or trailing '*'. Defaults to all databases",
+					},
+					"exclude": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Names of the databases not to inherit. Supports a leading or trailing '*'",
+					},
+					"root_username": {
+						Type:        framework.TypeString,
+						Description: "Root username of the clone, used if the clone rejects the root credentials of the source cluster. Defaults to the root username of the source",
+					},
+					"root_password": {
+						Type:        framework.TypeString,
+						Description: "Root password of the clone, used if the clone rejects the root credentials of the source cluster",
+					},
+					"dry_run": {
+						Type:        framework.TypeBool,
+						Default:     false,
+						Description: "If set to true the connection with the clone is validated and the databases that would be inherited are reported without changing the clone or the configuration",
+					},
+					"copy_metadata": {
+						Type:        framework.TypeBool,
+						Default:     false,
+						Description: "If set to true the metadata of the source cluster and the inherited databases is copied to the clone",
+					},
+					"metadata": {
+						Type:        framework.TypeKVPairs,
+						Description: "key-value pairs that override the copied metadata of the clone cluster and its databases",
+					},
 				},
 				Operations: map[logical.Operation]framework.OperationHandler{
 					logical.UpdateOperation: NewOperationHandler(b.pathCloneUpdate, propsCloneUpdate),
@@ -247,6 +475,10 @@ func New(c *logical.BackendConfig) *backend {
 						Description: "If true vault will create new database in cluster",
 						Default:     true,
 					},
+					"max_credential_ttl": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Upper limit for the TTL of credentials issued in this database. Zero means no limit",
+					},
 				},
 				Operations: map[logical.Operation]framework.OperationHandler{
 					logical.UpdateOperation: NewOperationHandler(b.pathDatabaseUpdate, propsDatabaseUpdate),
@@ -291,6 +523,72 @@ func New(c *logical.BackendConfig) *backend {
 						Description: "Database statements to drop a user and revoke permissions",
 						Default:     defaultRevocationSQL,
 					},
+					"renew_statement": {
+						Type:        framework.TypeStringSlice,
+						Description: "Database statements to extend the validity of a user on lease renewal",
+						Default:     defaultRenewSQL,
+					},
+					"revocation_mode": {
+						Type:        framework.TypeString,
+						Description: "Either 'drop' to execute the revocation statements, 'nologin' to disable the user, or 'nologin_then_drop_after' to disable the user and execute the revocation statements after 'revocation_retention'",
+						Default:     revocationModeDrop,
+					},
+					"revocation_retention": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Time to keep disabled users before they are dropped when 'revocation_mode' is 'nologin_then_drop_after'",
+					},
+					"credential_type": {
+						Type:        framework.TypeString,
+						Description: "Either 'password' to issue a password, or 'client_certificate' to issue a TLS client certificate signed by the client CA whose common name is the username",
+						Default:     credentialTypePassword,
+					},
+					"user_type": {
+						Type:        framework.TypeString,
+						Description: "Either 'dynamic' to create a new user for every lease, or 'entity' to use a persistent user for each Vault entity",
+						Default:     userTypeDynamic,
+					},
+					"login_statement": {
+						Type:        framework.TypeStringSlice,
+						Description: "Database statements to enable the login of an entity user on every lease",
+					},
+					"assume_objects_owner": {
+						Type:        framework.TypeBool,
+						Description: "If true the user will assume the objects owner role on login, so the objects created by the user are owned by the objects owner",
+					},
+					"allowed_groups": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Postgres groups that can be requested when generating credentials",
+					},
+					"execute_as": {
+						Type:        framework.TypeString,
+						Description: "Connection used to execute the role statements. Must be one of 'management' or 'root'",
+						Default:     connTypeMgmt.String(),
+					},
+					"databases": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Databases that the credentials can connect with. Setting this makes the role span multiple databases in a cluster",
+					},
+					"database_selector": {
+						Type:        framework.TypeString,
+						Description: "Selector expression matched against database metadata to select the databases that the credentials can connect with",
+					},
+					"database_creation_statement": {
+						Type:        framework.TypeStringSlice,
+						Description: "Database statements to grant access to the user, executed in every database of a role that spans multiple databases",
+					},
+					"database_revocation_statement": {
+						Type:        framework.TypeStringSlice,
+						Description: "Database statements to revoke access from the user, executed in every database of a role that spans multiple databases",
+					},
+					"privileges": {
+						Type:        framework.TypeSlice,
+						Description: "List of privileges compiled into the role statements. Each entry has 'schemas', 'object_types', 'privileges' and 'include_future' attributes",
+					},
+					"force": {
+						Type:        framework.TypeBool,
+						Description: "Delete the role even if leases issued from it are still outstanding",
+						Default:     false,
+					},
 				},
 				Operations: map[logical.Operation]framework.OperationHandler{
 					logical.UpdateOperation: NewOperationHandler(b.pathRoleUpdate, propsRoleUpdate),
@@ -300,6 +598,110 @@ func New(c *logical.BackendConfig) *backend {
 				HelpSynopsis:    helpSynopsisRoles,
 				HelpDescription: helpDescriptionRoles,
 			},
+			{
+				Pattern: "roles/" + framework.GenericNameRegex("name") + "/versions/?$",
+				Fields: map[string]*framework.FieldSchema{
+					"name": {
+						Type:        framework.TypeString,
+						Description: "Unique identifier for the role",
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ListOperation: NewOperationHandler(b.pathRoleVersionsList, propsRoleVersionsList),
+				},
+				HelpSynopsis:    helpSynopsisRoleVersions,
+				HelpDescription: helpDescriptionRoleVersions,
+			},
+			{
+				Pattern: "roles/" + framework.GenericNameRegex("name") + "/versions/(?P<version>\\d+)",
+				Fields: map[string]*framework.FieldSchema{
+					"name": {
+						Type:        framework.TypeString,
+						Description: "Unique identifier for the role",
+					},
+					"version": {
+						Type:        framework.TypeInt,
+						Description: "Version of the role",
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ReadOperation: NewOperationHandler(b.pathRoleVersionRead, propsRoleVersionRead),
+				},
+				HelpSynopsis:    helpSynopsisRoleVersions,
+				HelpDescription: helpDescriptionRoleVersions,
+			},
+			{
+				Pattern: "roles/" + framework.GenericNameRegex("name") + "/rollback",
+				Fields: map[string]*framework.FieldSchema{
+					"name": {
+						Type:        framework.TypeString,
+						Description: "Unique identifier for the role",
+					},
+					"version": {
+						Type:        framework.TypeInt,
+						Description: "Version of the role to restore",
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: NewOperationHandler(b.pathRoleRollback, propsRoleRollback),
+				},
+				HelpSynopsis:    helpSynopsisRoleRollback,
+				HelpDescription: helpDescriptionRoleRollback,
+			},
+			{
+				Pattern: "roles/" + framework.GenericNameRegex("name") + "/test",
+				Fields: map[string]*framework.FieldSchema{
+					"name": {
+						Type:        framework.TypeString,
+						Description: "Unique identifier for the role",
+					},
+					"cluster": {
+						Type:        framework.TypeString,
+						Description: "Name of the cluster to test the role against",
+					},
+					"database": {
+						Type:        framework.TypeString,
+						Description: "Name of the database to test the role against",
+					},
+					"mode": {
+						Type:        framework.TypeString,
+						Description: "Either 'rollback' to run all statements in a transaction that is rolled back, or 'commit' to create, connect and drop a real user",
+						Default:     dryRunModeRollback,
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: NewOperationHandler(b.pathRoleDryRun, propsRoleDryRun),
+				},
+				HelpSynopsis:    helpSynopsisRoleDryRun,
+				HelpDescription: helpDescriptionRoleDryRun,
+			},
+			{
+				Pattern: "creds-by-selector/" + framework.GenericNameRegex("role"),
+				Fields: map[string]*framework.FieldSchema{
+					"role": {
+						Type:        framework.TypeString,
+						Description: "Name of the role",
+					},
+					"selector": {
+						Type:        framework.TypeString,
+						Description: "Selector expression matched against database metadata, for example 'service=orders,env=prod'. Must match exactly one active database",
+					},
+					"ttl": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Requested TTL for the lease. Defaults to the default_ttl of role",
+					},
+					"groups": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Postgres groups to grant to the user. Must be allowed by the role",
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ReadOperation:   NewOperationHandler(b.secretCredsBySelectorCreate, propsCredsBySelectorRead),
+					logical.UpdateOperation: NewOperationHandler(b.secretCredsBySelectorCreate, propsCredsBySelectorUpdate),
+				},
+				HelpSynopsis:    helpSynopsisCredsBySelector,
+				HelpDescription: helpDescriptionCredsBySelector,
+			},
 			{
 				Pattern: "creds/" + framework.GenericNameRegex("cluster") + "/" + framework.GenericNameRegex("database") + "/" + framework.GenericNameRegex("role"),
 				Fields: map[string]*framework.FieldSchema{
@@ -315,13 +717,49 @@ func New(c *logical.BackendConfig) *backend {
 						Type:        framework.TypeString,
 						Description: "Name of the role",
 					},
+					"ttl": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Requested TTL for the lease. Defaults to the default_ttl of role",
+					},
+					"groups": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Postgres groups to grant to the user. Must be allowed by the role",
+					},
 				},
 				Operations: map[logical.Operation]framework.OperationHandler{
-					logical.ReadOperation: NewOperationHandler(b.secretCredsCreate, propsCredsRead),
+					logical.ReadOperation:   NewOperationHandler(b.secretCredsCreate, propsCredsRead),
+					logical.UpdateOperation: NewOperationHandler(b.secretCredsCreate, propsCredsUpdate),
 				},
 				HelpSynopsis:    helpSynopsisCreds,
 				HelpDescription: helpDescriptionCreds,
 			},
+			{
+				Pattern: "creds/" + framework.GenericNameRegex("cluster") + "/" + framework.GenericNameRegex("role"),
+				Fields: map[string]*framework.FieldSchema{
+					"cluster": {
+						Type:        framework.TypeString,
+						Description: "Name of the cluster",
+					},
+					"role": {
+						Type:        framework.TypeString,
+						Description: "Name of the role",
+					},
+					"ttl": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Requested TTL for the lease. Defaults to the default_ttl of role",
+					},
+					"groups": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Postgres groups to grant to the user. Must be allowed by the role",
+					},
+				},
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ReadOperation:   NewOperationHandler(b.secretClusterCredsCreate, propsClusterCredsRead),
+					logical.UpdateOperation: NewOperationHandler(b.secretClusterCredsCreate, propsClusterCredsUpdate),
+				},
+				HelpSynopsis:    helpSynopsisClusterCreds,
+				HelpDescription: helpDescriptionClusterCreds,
+			},
 			{
 				Pattern: "gc/clusters/?$",
 				Operations: map[logical.Operation]framework.OperationHandler{
@@ -366,12 +804,54 @@ func New(c *logical.BackendConfig) *backend {
 				HelpDescription: helpDescriptionGCDbOps,
 			},
 		},
-		Help: helpDescriptionBackend,
+		WALRollback:    b.walRollback,
+		PeriodicFunc:   b.periodicFunc,
+		InitializeFunc: b.initialize,
+		Help:           helpDescriptionBackend,
 	}
 
 	return &b
 }
 
+// initialize migrates the storage layout. Writes are not possible on
+// performance secondaries and standbys, the active node migrates instead.
+func (b *backend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	replicationState := b.System().ReplicationState()
+	if replicationState.HasState(consts.ReplicationPerformanceSecondary | consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+
+	migrated, err := migrateMetadataIDs(ctx, req.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to migrate metadata. %s", err)
+	}
+
+	if migrated > 0 {
+		b.Logger().Info("migrated database metadata to new storage layout", "entries", migrated)
+	}
+
+	return nil
+}
+
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	entityErr := b.cleanupEntityUsers(ctx, req.Storage)
+	disabledErr := b.dropDisabledUsers(ctx, req.Storage)
+	if entityErr != nil {
+		return entityErr
+	}
+
+	return disabledErr
+}
+
+func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	switch kind {
+	case walTypeDatabase:
+		return b.walRollbackDb(ctx, req, data)
+	default:
+		return fmt.Errorf("unknown WAL entry type %q", kind)
+	}
+}
+
 type connType int
 
 func (c connType) String() string {
@@ -390,6 +870,17 @@ const (
 	connTypeMgmt
 )
 
+func parseConnType(s string) (connType, error) {
+	switch s {
+	case connTypeRoot.String():
+		return connTypeRoot, nil
+	case connTypeMgmt.String():
+		return connTypeMgmt, nil
+	default:
+		return connTypeMgmt, fmt.Errorf("invalid connection type %q, valid options are 'management' or 'root'", s)
+	}
+}
+
 func (b *backend) getConn(ctx context.Context, storage logical.Storage, connT connType, cluster, db string) (*sql.DB, error) {
 	entry, err := storage.Get(ctx, PathCluster.For(cluster))
 	if err != nil {
@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	credentialTypePassword   = "password"
+	credentialTypeClientCert = "client_certificate"
+)
+
+// Users of client certificate roles are created without a password, they
+// authenticate using a certificate whose common name is the username.
+var defaultClientCertCreationSQL = []string{
+	"create role {{user}} with login inherit in role {{objects_owner}} role {{group}}",
+	"alter default privileges for role {{user}} grant all privileges on tables to {{objects_owner}}",
+	"alter default privileges for role {{user}} grant all privileges on sequences to {{objects_owner}}",
+}
+
+// The users of client certificate roles do not have a password
+var clientCertCreationTemplateVars = []string{"user", "expiration", "database", "objects_owner", "group"}
+
+// Certificates are backdated to tolerate clock skew between Vault and the cluster
+const clientCertBackdate = 30 * time.Second
+
+// ClientCA is the certificate authority that signs the client certificates
+type ClientCA struct {
+	Certificate *x509.Certificate
+	CertPEM     string
+	Signer      crypto.Signer
+}
+
+// parseClientCA parses the PEM encoded certificate and private key of a CA
+func parseClientCA(certPEM, keyPEM string) (*ClientCA, error) {
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("invalid client CA: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid client CA certificate: %s", err)
+	}
+
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return nil, fmt.Errorf("client CA certificate is not a CA")
+	}
+
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("client CA certificate cannot be used to sign certificates")
+	}
+
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("client CA private key cannot be used to sign certificates")
+	}
+
+	return &ClientCA{
+		Certificate: cert,
+		CertPEM:     strings.TrimSpace(certPEM),
+		Signer:      signer,
+	}, nil
+}
+
+// validateClientCA checks that the certificate and key are either both
+// set or both empty, and that they form a CA key pair.
+func validateClientCA(certPEM, keyPEM string) error {
+	if certPEM == "" && keyPEM == "" {
+		return nil
+	}
+
+	if certPEM == "" || keyPEM == "" {
+		return fmt.Errorf("client_ca_certificate and client_ca_private_key must be set together")
+	}
+
+	_, err := parseClientCA(certPEM, keyPEM)
+	return err
+}
+
+// getClientCA returns the CA of cluster, or the CA configured on the mount
+// if the cluster does not have one.
+func getClientCA(ctx context.Context, storage logical.Storage, cluster *ClusterConfig) (*ClientCA, error) {
+	if cluster.ClientCACertificate != "" {
+		return parseClientCA(cluster.ClientCACertificate, cluster.ClientCAPrivateKey)
+	}
+
+	cfg, err := loadMountConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ClientCACertificate == "" {
+		return nil, ErrNotFound
+	}
+
+	return parseClientCA(cfg.ClientCACertificate, cfg.ClientCAPrivateKey)
+}
+
+// ClientCertificate is a signed client certificate and its private key
+type ClientCertificate struct {
+	Certificate  string
+	PrivateKey   string
+	SerialNumber string
+	Expiration   time.Time
+}
+
+// issueClientCertificate signs a certificate for the user that expires at
+// notAfter, or with the CA if the CA expires earlier.
+func (ca *ClientCA) issueClientCertificate(username string, notAfter time.Time) (*ClientCertificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	if ca.Certificate.NotAfter.Before(notAfter) {
+		notAfter = ca.Certificate.NotAfter
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: username,
+		},
+		NotBefore:             time.Now().Add(-clientCertBackdate),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Certificate, key.Public(), ca.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientCertificate{
+		Certificate:  strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))),
+		PrivateKey:   strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))),
+		SerialNumber: formatSerial(serial),
+		Expiration:   notAfter,
+	}, nil
+}
+
+// formatSerial formats the serial number as colon separated hex bytes
+func formatSerial(serial *big.Int) string {
+	b := serial.Bytes()
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02x", c)
+	}
+
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,230 @@
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func testClientCA(t *testing.T, isCA bool) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key. %s", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create certificate. %s", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key. %s", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestClientCA_validate(t *testing.T) {
+	certPEM, keyPEM := testClientCA(t, true)
+	otherCert, _ := testClientCA(t, true)
+	leafCert, leafKey := testClientCA(t, false)
+
+	cases := []struct {
+		name  string
+		cert  string
+		key   string
+		valid bool
+	}{
+		{"not configured", "", "", true},
+		{"valid", certPEM, keyPEM, true},
+		{"missing key", certPEM, "", false},
+		{"mismatched key", otherCert, keyPEM, false},
+		{"not a ca", leafCert, leafKey, false},
+	}
+
+	for _, c := range cases {
+		err := validateClientCA(c.cert, c.key)
+		if c.valid && err != nil {
+			t.Errorf("%s: expected CA to be valid. %s", c.name, err)
+		}
+
+		if !c.valid && err == nil {
+			t.Errorf("%s: expected CA to be rejected", c.name)
+		}
+	}
+}
+
+func TestClientCA_issue(t *testing.T) {
+	certPEM, keyPEM := testClientCA(t, true)
+	ca, err := parseClientCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to parse CA. %s", err)
+	}
+
+	// The certificate cannot outlive the CA
+	issued, err := ca.issueClientCertificate("v-token-test", time.Now().Add(48*time.Hour))
+	if err != nil {
+		t.Fatalf("failed to issue certificate. %s", err)
+	}
+
+	block, _ := pem.Decode([]byte(issued.Certificate))
+	if block == nil {
+		t.Fatalf("expected PEM encoded certificate, got %q", issued.Certificate)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse issued certificate. %s", err)
+	}
+
+	if cert.Subject.CommonName != "v-token-test" {
+		t.Fatalf("expected common name to be the username, got %s", cert.Subject.CommonName)
+	}
+
+	if !cert.NotAfter.Equal(ca.Certificate.NotAfter) {
+		t.Fatalf("expected certificate to expire with the CA at %s, got %s", ca.Certificate.NotAfter, cert.NotAfter)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatalf("expected certificate to be valid for client auth. %s", err)
+	}
+}
+
+func TestCredsCreate_clientCANotConfigured(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	err := storeClusterEntry(ctx, storage, testCluster, &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"})
+	if err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	err = storeDbEntry(ctx, storage, testCluster, testDb, &DbConfig{Cluster: testCluster, Database: testDb, ObjectsOwner: "owner"})
+	if err != nil {
+		t.Fatalf("failed to store database. %s", err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRole,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"credential_type": credentialTypeClientCert,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write role. err: %v, resp: %v", err, resp)
+	}
+
+	role, err := loadRoleEntry(ctx, storage, testRole)
+	if err != nil {
+		t.Fatalf("failed to load role. %s", err)
+	}
+
+	if strings.Contains(strings.Join(role.CreationStatement, ";"), "{{password}}") {
+		t.Fatalf("expected creation statement without password, got %v", role.CreationStatement)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/" + testCluster + "/" + testDb + "/" + testRole,
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error. %s", err)
+	}
+
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "client CA") {
+		t.Fatalf("expected missing client CA to be rejected, got %v", resp)
+	}
+}
+
+func TestAccCredsCreate_clientCertificate(t *testing.T) {
+	b := testGetBackend(t)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	certPEM, keyPEM := testClientCA(t, true)
+
+	clusterAttr := map[string]interface{}{
+		"client_ca_certificate": certPEM,
+		"client_ca_private_key": keyPEM,
+	}
+	for k, v := range attr {
+		clusterAttr[k] = v
+	}
+
+	requests := []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"cluster/" + testCluster, clusterAttr},
+		{"cluster/" + testCluster + "/" + testDb, nil},
+		{"roles/" + testRole, map[string]interface{}{"credential_type": credentialTypeClientCert}},
+	}
+
+	for _, r := range requests {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      r.path,
+			Storage:   storage,
+			Data:      r.data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("request to %s failed. err: %v, resp: %v", r.path, err, resp)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/" + testCluster + "/" + testDb + "/" + testRole,
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("failed to read creds. err: %v, resp: %v", err, resp)
+	}
+
+	serial, ok := resp.Data["serial_number"].(string)
+	if !ok || serial == "" {
+		t.Fatalf("expected serial number in response, got %+v", resp.Data)
+	}
+
+	if resp.Secret.InternalData["serial_number"] != serial {
+		t.Fatalf("expected serial number %s in the lease, got %+v", serial, resp.Secret.InternalData)
+	}
+
+	if resp.Secret.Renewable {
+		t.Fatalf("expected certificate lease to not be renewable")
+	}
+}
@@ -0,0 +1,298 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/dbtxn"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lib/pq"
+)
+
+const (
+	revocationModeDrop            = "drop"
+	revocationModeNologin         = "nologin"
+	revocationModeNologinThenDrop = "nologin_then_drop_after"
+)
+
+// DisabledUser records a user that was disabled on revocation and
+// has to be dropped once the retention period is over
+type DisabledUser struct {
+	Username                    string    `json:"username"`
+	Cluster                     string    `json:"cluster"`
+	Database                    string    `json:"database"`
+	Databases                   []string  `json:"databases"`
+	ExecuteAs                   string    `json:"execute_as"`
+	RevocationStatement         []string  `json:"revocation_statement"`
+	DatabaseRevocationStatement []string  `json:"database_revocation_statement"`
+	DisabledAt                  time.Time `json:"disabled_at"`
+	DropAfter                   time.Time `json:"drop_after"`
+}
+
+func loadDisabledUser(ctx context.Context, storage logical.Storage, cluster, username string) (*DisabledUser, error) {
+	entry, err := storage.Get(ctx, PathDisabledUser.For(cluster, username))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+
+	u := &DisabledUser{}
+	err = entry.DecodeJSON(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func storeDisabledUser(ctx context.Context, storage logical.Storage, u *DisabledUser) error {
+	entry, err := logical.StorageEntryJSON(PathDisabledUser.For(u.Cluster, u.Username), u)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// getRevocationMode returns the revocation mode and retention captured in the
+// lease. Leases issued before the mode was configurable drop the user.
+func getRevocationMode(data map[string]interface{}) (string, time.Duration) {
+	mode, ok := data["revocation_mode"].(string)
+	if !ok || mode == "" {
+		return revocationModeDrop, 0
+	}
+
+	// The retention is stored as a duration string since the internal
+	// data of lease does not preserve the numeric types
+	var retention time.Duration
+	if v, ok := data["revocation_retention"].(string); ok {
+		retention, _ = time.ParseDuration(v)
+	}
+
+	return mode, retention
+}
+
+// disableUser removes the login and all role memberships of the user
+func (b *backend) disableUser(ctx context.Context, storage logical.Storage, connT connType, clusterName, username string) error {
+	db, err := b.getConn(ctx, storage, connT, clusterName, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, queryUserMemberships, username)
+	if err != nil {
+		return err
+	}
+
+	var memberships []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		memberships = append(memberships, name)
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	_, revoke := groupStatements(memberships)
+	m := map[string]string{
+		"user": pq.QuoteIdentifier(username),
+	}
+
+	for _, query := range append(revoke, queryDisableLogin) {
+		if err := dbtxn.ExecuteTxQuery(ctx, tx, m, query); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// dropDisabledUsers drops the disabled users whose retention period is over.
+// Users that cannot be dropped are retried on the next run.
+func (b *backend) dropDisabledUsers(ctx context.Context, storage logical.Storage) error {
+	clusters, err := storage.List(ctx, PathDisabledUsers.For())
+	if err != nil {
+		return err
+	}
+
+	for _, c := range clusters {
+		clusterName := strings.TrimSuffix(c, "/")
+		users, err := storage.List(ctx, PathDisabledUser.For(clusterName, ""))
+		if err != nil {
+			return err
+		}
+
+		for _, username := range users {
+			if err := b.dropDisabledUser(ctx, storage, clusterName, username); err != nil {
+				b.Logger().Warn("failed to drop disabled user", "cluster", clusterName, "username", username, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *backend) dropDisabledUser(ctx context.Context, storage logical.Storage, clusterName, username string) error {
+	u, err := loadDisabledUser(ctx, storage, clusterName, username)
+	if err == ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if time.Now().Before(u.DropAfter) {
+		return nil
+	}
+
+	// The record is kept when the configuration is gone, the user still
+	// exists in the cluster and has to be dropped manually
+	cluster, err := loadClusterEntry(ctx, storage, clusterName)
+	if err == ErrNotFound {
+		return fmt.Errorf("configuration for cluster %s cannot be found", clusterName)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	connT, err := parseConnType(u.ExecuteAs)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	warn := func(msg string) {
+		failures = append(failures, msg)
+	}
+
+	if u.Database != "" {
+		database, err := loadDbEntry(ctx, storage, clusterName, u.Database)
+		if err == ErrNotFound {
+			return fmt.Errorf("configuration for database %s cannot be found", u.Database)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = b.execInDatabase(ctx, storage, connT, clusterName, u.Database, restoreOwnerMembership(u.RevocationStatement),
+			databaseTemplateMap(username, cluster, database), func(idx int, query string, err error) {
+				warn(fmt.Sprintf("failed to run revocation query [%d]: %q - %s", idx, query, err))
+			})
+		if err != nil {
+			return err
+		}
+	} else {
+		var databases []*DbConfig
+		for _, name := range u.Databases {
+			database, err := loadDbEntry(ctx, storage, clusterName, name)
+			if err == ErrNotFound {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			databases = append(databases, database)
+		}
+
+		err = b.revokeClusterUser(ctx, storage, connT, clusterName, cluster, username, databases,
+			restoreOwnerMembership(u.DatabaseRevocationStatement), u.RevocationStatement, warn)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+
+	b.Logger().Info("dropped disabled user", "cluster", clusterName, "username", username, "disabled_at", u.DisabledAt)
+	return storage.Delete(ctx, PathDisabledUser.For(clusterName, username))
+}
+
+// listPendingDisabledUsers returns the disabled users of the cluster that are
+// not dropped yet. When database is set, only the users of the database are returned.
+func listPendingDisabledUsers(ctx context.Context, storage logical.Storage, clusterName, database string) ([]string, error) {
+	users, err := storage.List(ctx, PathDisabledUser.For(clusterName, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	if database == "" {
+		return users, nil
+	}
+
+	var pending []string
+	for _, username := range users {
+		u, err := loadDisabledUser(ctx, storage, clusterName, username)
+		if err == ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if u.Database == database || strutil.StrListContains(u.Databases, database) {
+			pending = append(pending, username)
+		}
+	}
+
+	return pending, nil
+}
+
+// restoreOwnerMembership prepends the grant of the objects owner to the
+// revocation statements. The membership is revoked when the user is disabled
+// but the statements need it to reassign the objects owned by the user.
+func restoreOwnerMembership(statements []string) []string {
+	return append([]string{queryGrantObjectsOwner}, statements...)
+}
+
+// revokeByDisabling disables the user instead of executing the revocation
+// statements. The statements are recorded to drop the user after retention.
+func (b *backend) revokeByDisabling(ctx context.Context, storage logical.Storage, connT connType, mode string, retention time.Duration, u *DisabledUser) (string, error) {
+	err := b.disableUser(ctx, storage, connT, u.Cluster, u.Username)
+	if err != nil {
+		return "", err
+	}
+
+	if mode != revocationModeNologinThenDrop {
+		return fmt.Sprintf("User %s is disabled and will not be dropped", u.Username), nil
+	}
+
+	u.ExecuteAs = connT.String()
+	u.DisabledAt = time.Now()
+	u.DropAfter = u.DisabledAt.Add(retention)
+	err = storeDisabledUser(ctx, storage, u)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("User %s is disabled and will be dropped after %s", u.Username, u.DropAfter.UTC().Format(time.RFC3339)), nil
+}
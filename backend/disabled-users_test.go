@@ -0,0 +1,229 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestGetRevocationMode(t *testing.T) {
+	mode, retention := getRevocationMode(map[string]interface{}{})
+	if mode != revocationModeDrop || retention != 0 {
+		t.Fatalf("expected leases without a mode to drop the user, got %s %s", mode, retention)
+	}
+
+	mode, retention = getRevocationMode(map[string]interface{}{
+		"revocation_mode":      revocationModeNologinThenDrop,
+		"revocation_retention": (2 * time.Hour).String(),
+	})
+	if mode != revocationModeNologinThenDrop || retention != 2*time.Hour {
+		t.Fatalf("expected the mode and retention of lease, got %s %s", mode, retention)
+	}
+}
+
+func TestDropDisabledUsers_retention(t *testing.T) {
+	b := testGetBackend(t).(*backend)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	u := &DisabledUser{
+		Username:  "v-token-disabled",
+		Cluster:   testCluster,
+		Database:  testDb,
+		ExecuteAs: connTypeMgmt.String(),
+		DropAfter: time.Now().Add(time.Hour),
+	}
+
+	if err := storeDisabledUser(ctx, storage, u); err != nil {
+		t.Fatalf("failed to store disabled user. %s", err)
+	}
+
+	if err := b.dropDisabledUsers(ctx, storage); err != nil {
+		t.Fatalf("failed to drop disabled users. %s", err)
+	}
+
+	if _, err := loadDisabledUser(ctx, storage, testCluster, u.Username); err != nil {
+		t.Fatalf("expected user to be kept until the retention is over. %v", err)
+	}
+
+	// The cluster does not exist so the user cannot be dropped and is kept once it is due
+	u.DropAfter = time.Now().Add(-time.Minute)
+	if err := storeDisabledUser(ctx, storage, u); err != nil {
+		t.Fatalf("failed to store disabled user. %s", err)
+	}
+
+	if err := b.dropDisabledUser(ctx, storage, testCluster, u.Username); err == nil {
+		t.Fatalf("expected drop to fail without cluster configuration")
+	}
+
+	if _, err := loadDisabledUser(ctx, storage, testCluster, u.Username); err != nil {
+		t.Fatalf("expected user to be kept without cluster configuration. %v", err)
+	}
+}
+
+func TestDropDisabledUsers_gcPurge(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	cluster := &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"}
+	cluster.Disable()
+	if err := storeClusterEntry(ctx, storage, testCluster, cluster); err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	database := &DbConfig{Cluster: testCluster, Database: testDb, ObjectsOwner: "owner"}
+	database.Disable()
+	if err := storeDbEntry(ctx, storage, testCluster, testDb, database); err != nil {
+		t.Fatalf("failed to store database. %s", err)
+	}
+
+	u := &DisabledUser{
+		Username:  "v-token-disabled",
+		Cluster:   testCluster,
+		Database:  testDb,
+		ExecuteAs: connTypeMgmt.String(),
+		DropAfter: time.Now().Add(time.Hour),
+	}
+
+	if err := storeDisabledUser(ctx, storage, u); err != nil {
+		t.Fatalf("failed to store disabled user. %s", err)
+	}
+
+	for _, p := range []string{"gc/cluster/" + testCluster + "/" + testDb, "gc/cluster/" + testCluster} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      p,
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error. %s", err)
+		}
+
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected purge of %s to be refused while disabled users exist, got %v", p, resp)
+		}
+	}
+
+	if _, err := loadDbEntry(ctx, storage, testCluster, testDb); err != nil {
+		t.Fatalf("expected database to be kept. %v", err)
+	}
+}
+
+func TestAccDisabledUsers_dropAfterRetention(t *testing.T) {
+	b := testGetBackend(t).(*backend)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("request to %s failed. err: %v, resp: %v", path, err, resp)
+		}
+
+		return resp
+	}
+
+	request("cluster/"+testCluster, attr)
+	request("cluster/"+testCluster+"/"+testDb, nil)
+	request("roles/"+testRole, map[string]interface{}{
+		"revocation_mode":      revocationModeNologinThenDrop,
+		"revocation_retention": 3600,
+	})
+
+	cluster, err := loadClusterEntry(ctx, storage, testCluster)
+	if err != nil {
+		t.Fatalf("failed to load cluster. %s", err)
+	}
+
+	database, err := loadDbEntry(ctx, storage, testCluster, testDb)
+	if err != nil {
+		t.Fatalf("failed to load database. %s", err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/" + testCluster + "/" + testDb + "/" + testRole,
+		Storage:   storage,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds. err: %v, resp: %v", err, resp)
+	}
+
+	u, p := resp.Data["username"].(string), resp.Data["password"].(string)
+	conn, err := sql.Open("postgres", cluster.dsnForUser(u, p, testDb))
+	if err != nil {
+		t.Fatalf("failed to connect using issued creds. %s", err)
+	}
+
+	if _, err := conn.Exec("create table users (id serial primary key)"); err != nil {
+		t.Fatalf("failed to create table as issued user. %s", err)
+	}
+	_ = conn.Close()
+
+	revResp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   storage,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (revResp != nil && revResp.IsError()) {
+		t.Fatalf("failed to revoke lease. err: %v, resp: %v", err, revResp)
+	}
+
+	root, err := sql.Open("postgres", cluster.dsnForDb(connTypeRoot, testDb))
+	if err != nil {
+		t.Fatalf("failed to open database connection. %s", err)
+	}
+	defer root.Close()
+
+	var memberships int
+	if err := root.QueryRow("select count(*) from pg_auth_members m join pg_roles u on u.oid = m.member where u.rolname = $1", u).Scan(&memberships); err != nil {
+		t.Fatalf("failed to count memberships. %s", err)
+	}
+
+	if memberships != 0 {
+		t.Fatalf("expected disabled user to have no memberships, got %d", memberships)
+	}
+
+	disabled, err := loadDisabledUser(ctx, storage, testCluster, u)
+	if err != nil {
+		t.Fatalf("failed to load disabled user. %s", err)
+	}
+
+	disabled.DropAfter = time.Now().Add(-time.Minute)
+	if err := storeDisabledUser(ctx, storage, disabled); err != nil {
+		t.Fatalf("failed to store disabled user. %s", err)
+	}
+
+	if err := b.dropDisabledUser(ctx, storage, testCluster, u); err != nil {
+		t.Fatalf("failed to drop disabled user. %s", err)
+	}
+
+	if _, err := loadDisabledUser(ctx, storage, testCluster, u); err != ErrNotFound {
+		t.Fatalf("expected disabled user to be removed, got %v", err)
+	}
+
+	var exists bool
+	if err := root.QueryRow(queryRoleExists, u).Scan(&exists); err != nil || exists {
+		t.Fatalf("expected user %s to be dropped. err: %v", u, err)
+	}
+
+	var owner string
+	if err := root.QueryRow("select tableowner from pg_tables where tablename = 'users'").Scan(&owner); err != nil {
+		t.Fatalf("failed to read table owner. %s", err)
+	}
+
+	if owner != database.ObjectsOwner {
+		t.Fatalf("expected table to be reassigned to %s, got %s", database.ObjectsOwner, owner)
+	}
+}
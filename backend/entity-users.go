@@ -0,0 +1,264 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	userTypeDynamic = "dynamic"
+	userTypeEntity  = "entity"
+)
+
+// Entity users are created once and enabled on every lease, revocation
+// only disables the login so the user keeps its objects and settings.
+var defaultEntityLoginSQL = []string{
+	"alter role {{user}} with login password '{{password}}' valid until '{{expiration}}'",
+	"grant {{objects_owner}} to {{user}}",
+}
+
+var defaultEntityRevocationSQL = []string{
+	"alter role {{user}} with nologin",
+}
+
+// Statements to drop the user of a deleted entity from each database
+// the user was issued for, followed by the maintenance database
+var (
+	entityDropDatabaseSQL = []string{
+		"reassign owned by {{user}} to {{objects_owner}}",
+		"drop owned by {{user}}",
+	}
+
+	entityDropSQL = []string{
+		"drop role if exists {{user}}",
+	}
+)
+
+// EntityUser tracks the persistent user of a Vault entity in a cluster
+// along with the leases that currently enable its login and the roles
+// granted by each lease
+type EntityUser struct {
+	EntityID    string                  `json:"entity_id"`
+	Cluster     string                  `json:"cluster"`
+	Username    string                  `json:"username"`
+	Databases   []string                `json:"databases"`
+	Leases      []string                `json:"leases"`
+	LeaseGrants map[string]*EntityLease `json:"lease_grants"`
+}
+
+// EntityLease records the roles granted to the user of an entity by a
+// lease, which are the requested groups and the objects owner of the
+// database the lease was issued for.
+type EntityLease struct {
+	Groups       []string `json:"groups"`
+	ObjectsOwner string   `json:"objects_owner"`
+}
+
+// Roles returns the names of all roles granted by the lease
+func (l *EntityLease) Roles() []string {
+	roles := append([]string{}, l.Groups...)
+	if l.ObjectsOwner != "" && !strutil.StrListContains(roles, l.ObjectsOwner) {
+		roles = append(roles, l.ObjectsOwner)
+	}
+
+	return roles
+}
+
+// entityUsername returns the stable name of the user of an entity
+func entityUsername(entityID string) string {
+	return fmt.Sprintf("v-entity-%s", entityID)
+}
+
+func loadEntityUser(ctx context.Context, storage logical.Storage, cluster, username string) (*EntityUser, error) {
+	entry, err := storage.Get(ctx, PathEntityUser.For(cluster, username))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+
+	u := &EntityUser{}
+	err = entry.DecodeJSON(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func storeEntityUser(ctx context.Context, storage logical.Storage, u *EntityUser) error {
+	entry, err := logical.StorageEntryJSON(PathEntityUser.For(u.Cluster, u.Username), u)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// trackEntityLease records the lease and the roles it grants on the user of entity
+func (b *backend) trackEntityLease(ctx context.Context, storage logical.Storage, entityID, cluster, database, username, leaseRef string, grants *EntityLease) error {
+	b.entityUsersLock.Lock()
+	defer b.entityUsersLock.Unlock()
+
+	u, err := loadEntityUser(ctx, storage, cluster, username)
+	if err == ErrNotFound {
+		u = &EntityUser{
+			EntityID: entityID,
+			Cluster:  cluster,
+			Username: username,
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if !strutil.StrListContains(u.Databases, database) {
+		u.Databases = append(u.Databases, database)
+	}
+
+	if u.LeaseGrants == nil {
+		u.LeaseGrants = map[string]*EntityLease{}
+	}
+
+	u.Leases = append(u.Leases, leaseRef)
+	u.LeaseGrants[leaseRef] = grants
+	return storeEntityUser(ctx, storage, u)
+}
+
+// releaseEntityLease removes the lease from the user of entity and returns
+// the number of leases that still enable the login of user, along with the
+// roles granted by the released lease that none of those leases needs. The
+// grants of released are used if the lease was tracked without its grants.
+func (b *backend) releaseEntityLease(ctx context.Context, storage logical.Storage, cluster, username, leaseRef string, released *EntityLease) (int, []string, error) {
+	b.entityUsersLock.Lock()
+	defer b.entityUsersLock.Unlock()
+
+	u, err := loadEntityUser(ctx, storage, cluster, username)
+	if err == ErrNotFound {
+		return 0, released.Roles(), nil
+	}
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if tracked, ok := u.LeaseGrants[leaseRef]; ok && tracked != nil {
+		released = tracked
+	}
+
+	u.Leases = strutil.StrListDelete(u.Leases, leaseRef)
+	delete(u.LeaseGrants, leaseRef)
+
+	revoke := released.Roles()
+	for _, ref := range u.Leases {
+		grants, ok := u.LeaseGrants[ref]
+		if !ok || grants == nil {
+			// Leases issued before the grants were tracked may need any role
+			revoke = nil
+			break
+		}
+
+		revoke = strutil.Difference(revoke, grants.Roles(), false)
+	}
+
+	return len(u.Leases), revoke, storeEntityUser(ctx, storage, u)
+}
+
+// cleanupEntityUsers drops the users of entities that no longer exist. Users
+// with outstanding leases are left alone until the leases are revoked.
+func (b *backend) cleanupEntityUsers(ctx context.Context, storage logical.Storage) error {
+	clusters, err := storage.List(ctx, PathEntityUsers.For())
+	if err != nil {
+		return err
+	}
+
+	for _, c := range clusters {
+		clusterName := strings.TrimSuffix(c, "/")
+		users, err := storage.List(ctx, PathEntityUser.For(clusterName, ""))
+		if err != nil {
+			return err
+		}
+
+		for _, username := range users {
+			if err := b.cleanupEntityUser(ctx, storage, clusterName, username); err != nil {
+				b.Logger().Warn("failed to cleanup user of deleted entity", "cluster", clusterName, "username", username, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *backend) cleanupEntityUser(ctx context.Context, storage logical.Storage, clusterName, username string) error {
+	b.entityUsersLock.Lock()
+	defer b.entityUsersLock.Unlock()
+
+	u, err := loadEntityUser(ctx, storage, clusterName, username)
+	if err == ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(u.Leases) > 0 {
+		return nil
+	}
+
+	entity, err := b.System().EntityInfo(u.EntityID)
+	if err != nil {
+		return err
+	}
+
+	if entity != nil {
+		return nil
+	}
+
+	cluster, err := loadClusterEntry(ctx, storage, clusterName)
+	if err == ErrNotFound {
+		return storage.Delete(ctx, PathEntityUser.For(clusterName, username))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var databases []*DbConfig
+	for _, name := range u.Databases {
+		database, err := loadDbEntry(ctx, storage, clusterName, name)
+		if err == ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		databases = append(databases, database)
+	}
+
+	failed := false
+	warn := func(msg string) {
+		failed = true
+		b.Logger().Warn("failed to drop user of deleted entity", "cluster", clusterName, "username", username, "error", msg)
+	}
+
+	err = b.revokeClusterUser(ctx, storage, connTypeMgmt, clusterName, cluster, username, databases, entityDropDatabaseSQL, entityDropSQL, warn)
+	if err != nil {
+		return err
+	}
+
+	// The user is tracked until it is dropped so the cleanup is retried
+	if failed {
+		return fmt.Errorf("user %s was not dropped", username)
+	}
+
+	b.Logger().Info("dropped user of deleted entity", "cluster", clusterName, "username", username, "entity_id", u.EntityID)
+	return storage.Delete(ctx, PathEntityUser.For(clusterName, username))
+}
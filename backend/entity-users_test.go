@@ -0,0 +1,290 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestEntityUserLeases(t *testing.T) {
+	b := testGetBackend(t).(*backend)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+	username := entityUsername("entity-id")
+
+	leases := map[string]*EntityLease{
+		"lease-one":   {Groups: []string{"readers", "writers"}, ObjectsOwner: "orders_owner"},
+		"lease-two":   {Groups: []string{"readers"}, ObjectsOwner: "billing_owner"},
+		"lease-three": {ObjectsOwner: "billing_owner"},
+	}
+
+	for _, ref := range []string{"lease-one", "lease-two", "lease-three"} {
+		if err := b.trackEntityLease(ctx, storage, "entity-id", testCluster, testDb, username, ref, leases[ref]); err != nil {
+			t.Fatalf("failed to track lease. %s", err)
+		}
+	}
+
+	u, err := loadEntityUser(ctx, storage, testCluster, username)
+	if err != nil {
+		t.Fatalf("failed to load entity user. %s", err)
+	}
+
+	if len(u.Databases) != 1 || len(u.Leases) != 3 {
+		t.Fatalf("expected one database and three leases, got %+v", u)
+	}
+
+	remaining, revoke, err := b.releaseEntityLease(ctx, storage, testCluster, username, "lease-one", &EntityLease{})
+	if err != nil || remaining != 2 {
+		t.Fatalf("expected two remaining leases, got %d. err: %v", remaining, err)
+	}
+
+	if !reflect.DeepEqual(revoke, []string{"orders_owner", "writers"}) {
+		t.Fatalf("expected the roles no other lease needs to be revoked, got %v", revoke)
+	}
+
+	remaining, revoke, err = b.releaseEntityLease(ctx, storage, testCluster, username, "lease-two", &EntityLease{})
+	if err != nil || remaining != 1 || !reflect.DeepEqual(revoke, []string{"readers"}) {
+		t.Fatalf("expected one remaining lease and readers to be revoked, got %d %v. err: %v", remaining, revoke, err)
+	}
+
+	remaining, revoke, err = b.releaseEntityLease(ctx, storage, testCluster, username, "lease-three", &EntityLease{})
+	if err != nil || remaining != 0 || !reflect.DeepEqual(revoke, []string{"billing_owner"}) {
+		t.Fatalf("expected no remaining lease and the objects owner to be revoked, got %d %v. err: %v", remaining, revoke, err)
+	}
+
+	// Leases tracked before the grants were recorded may need any role, and
+	// the grants of the caller are used for untracked released leases
+	u.Leases = []string{"legacy", "lease-four", "lease-five"}
+	u.LeaseGrants = map[string]*EntityLease{"lease-five": {ObjectsOwner: "orders_owner"}}
+	if err := storeEntityUser(ctx, storage, u); err != nil {
+		t.Fatalf("failed to store entity user. %s", err)
+	}
+
+	_, revoke, err = b.releaseEntityLease(ctx, storage, testCluster, username, "lease-four", &EntityLease{Groups: []string{"writers"}})
+	if err != nil || len(revoke) != 0 {
+		t.Fatalf("expected roles to be held by the legacy lease, got %v. err: %v", revoke, err)
+	}
+
+	_, revoke, err = b.releaseEntityLease(ctx, storage, testCluster, username, "legacy", &EntityLease{Groups: []string{"writers"}, ObjectsOwner: "orders_owner"})
+	if err != nil || !reflect.DeepEqual(revoke, []string{"writers"}) {
+		t.Fatalf("expected the grants of caller to be used for the legacy lease, got %v. err: %v", revoke, err)
+	}
+}
+
+func TestAccCredsRevoke_entityGroups(t *testing.T) {
+	b := testGetBackend(t)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+			EntityID:  "entity-id",
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed. err: %v, resp: %v", op, path, err, resp)
+		}
+
+		return resp
+	}
+
+	request(logical.UpdateOperation, "cluster/"+testCluster, attr)
+	request(logical.UpdateOperation, "cluster/"+testCluster+"/"+testDb, nil)
+
+	cluster, err := loadClusterEntry(ctx, storage, testCluster)
+	if err != nil {
+		t.Fatalf("failed to load cluster. %s", err)
+	}
+
+	root, err := sql.Open("postgres", cluster.dsn(connTypeRoot))
+	if err != nil {
+		t.Fatalf("failed to open database connection. %s", err)
+	}
+	defer root.Close()
+
+	for _, g := range []string{"readers", "writers"} {
+		if _, err := root.Exec("create role " + g); err != nil {
+			t.Fatalf("failed to create group %s. %s", g, err)
+		}
+	}
+
+	request(logical.UpdateOperation, "roles/"+testRole, map[string]interface{}{
+		"user_type":      userTypeEntity,
+		"allowed_groups": []string{"readers", "writers"},
+	})
+
+	credsPath := "creds/" + testCluster + "/" + testDb + "/" + testRole
+	first := request(logical.UpdateOperation, credsPath, map[string]interface{}{"groups": []string{"readers", "writers"}})
+	request(logical.UpdateOperation, credsPath, map[string]interface{}{"groups": []string{"readers"}})
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   storage,
+		Secret:    first.Secret,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to revoke lease. err: %v, resp: %v", err, resp)
+	}
+
+	username := entityUsername("entity-id")
+	member := func(group string) bool {
+		var ok bool
+		err := root.QueryRow("select pg_has_role($1, $2, 'member')", username, group).Scan(&ok)
+		if err != nil {
+			t.Fatalf("failed to check membership of %s. %s", group, err)
+		}
+
+		return ok
+	}
+
+	if !member("readers") {
+		t.Fatalf("expected group requested by the remaining lease to be kept")
+	}
+
+	if member("writers") {
+		t.Fatalf("expected group of the revoked lease to be revoked")
+	}
+}
+
+func TestCredsCreate_entityRequired(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	err := storeClusterEntry(ctx, storage, testCluster, &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"})
+	if err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	err = storeDbEntry(ctx, storage, testCluster, testDb, &DbConfig{Cluster: testCluster, Database: testDb, ObjectsOwner: "owner"})
+	if err != nil {
+		t.Fatalf("failed to store database. %s", err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRole,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"user_type": userTypeEntity,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write role. err: %v, resp: %v", err, resp)
+	}
+
+	role, err := loadRoleEntry(ctx, storage, testRole)
+	if err != nil {
+		t.Fatalf("failed to load role. %s", err)
+	}
+
+	if role.RevocationStatement[0] != defaultEntityRevocationSQL[0] {
+		t.Fatalf("expected entity revocation statement by default, got %v", role.RevocationStatement)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/" + testCluster + "/" + testDb + "/" + testRole,
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error. %s", err)
+	}
+
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected request without entity to be rejected, got %v", resp)
+	}
+}
+
+func TestAccCredsRevoke_entityObjectsOwners(t *testing.T) {
+	b := testGetBackend(t)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	request := func(op logical.Operation, path string, data map[string]interface{}, secret *logical.Secret) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+			Secret:    secret,
+			EntityID:  "entity-id",
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed. err: %v, resp: %v", op, path, err, resp)
+		}
+
+		return resp
+	}
+
+	databases := []string{"test-entity-orders", "test-entity-billing"}
+	request(logical.UpdateOperation, "cluster/"+testCluster, attr, nil)
+	for _, name := range databases {
+		request(logical.UpdateOperation, "cluster/"+testCluster+"/"+name, nil, nil)
+	}
+
+	request(logical.UpdateOperation, "roles/"+testRole, map[string]interface{}{"user_type": userTypeEntity}, nil)
+
+	cluster, err := loadClusterEntry(ctx, storage, testCluster)
+	if err != nil {
+		t.Fatalf("failed to load cluster. %s", err)
+	}
+
+	root, err := sql.Open("postgres", cluster.dsn(connTypeRoot))
+	if err != nil {
+		t.Fatalf("failed to open database connection. %s", err)
+	}
+	defer root.Close()
+
+	var owners []string
+	var leases []*logical.Secret
+	for _, name := range databases {
+		database, err := loadDbEntry(ctx, storage, testCluster, name)
+		if err != nil {
+			t.Fatalf("failed to load database. %s", err)
+		}
+
+		owners = append(owners, database.ObjectsOwner)
+		resp := request(logical.ReadOperation, "creds/"+testCluster+"/"+name+"/"+testRole, nil, nil)
+		leases = append(leases, resp.Secret)
+	}
+
+	username := entityUsername("entity-id")
+	member := func(owner string) bool {
+		var ok bool
+		if err := root.QueryRow(queryUserHasRole, username, owner).Scan(&ok); err != nil {
+			t.Fatalf("failed to check membership of %s. %s", owner, err)
+		}
+
+		return ok
+	}
+
+	if !member(owners[0]) || !member(owners[1]) {
+		t.Fatalf("expected user to be a member of the objects owner of both databases")
+	}
+
+	// The lease on the second database must not keep the owner of the first
+	request(logical.RevokeOperation, "", nil, leases[0])
+	if member(owners[0]) {
+		t.Fatalf("expected objects owner %s to be revoked with its lease", owners[0])
+	}
+
+	if !member(owners[1]) {
+		t.Fatalf("expected objects owner %s of the remaining lease to be kept", owners[1])
+	}
+
+	request(logical.RevokeOperation, "", nil, leases[1])
+	if member(owners[1]) {
+		t.Fatalf("expected objects owner %s to be revoked with the last lease", owners[1])
+	}
+}
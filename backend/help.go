@@ -12,6 +12,23 @@ Returns the build information about the plugin.
 
 	helpDescriptionInfo = ``
 
+	helpSynopsisConfig = `
+Configure the secret engine.
+`
+
+	helpDescriptionConfig = `
+This endpoint configures the settings that apply to the whole secret engine.
+
+The 'root_execution_roles' parameter lists the roles that are allowed to execute
+their statements using the root connection of a cluster, see 'execute_as' on
+roles. All other roles must use the management connection.
+
+The 'client_ca_certificate' and 'client_ca_private_key' parameters configure the CA
+that signs the certificates issued by roles with 'credential_type' set to
+'client_certificate'. A cluster can override it with its own CA. The private key is
+never returned when reading the configuration.
+`
+
 	helpSynopsisCluster = `
 Write, Read and Delete cluster configuration.
 `
@@ -26,6 +43,13 @@ registered.
 Reading from this endpoint should be protected since reading cluster configuration
 will send back the password for both root and management users.
 
+The 'client_ca_certificate' and 'client_ca_private_key' parameters set the CA that
+signs the client certificates issued for this cluster, overriding the CA of the mount.
+The cluster should trust this CA for client authentication.
+
+The passwords of the root and management users are hashed by the plugin before they
+are sent to the cluster, and the statement logging is disabled while they are changed.
+
 Deleting a cluster has no effect on the actual resource. Vault still retains the
 configuration for a deleted cluster but the cluster is marked as 'disabled'.
 Disabling a cluster prevents creation of new databases or credentials in it and
@@ -35,6 +59,44 @@ lease will be revoked on expiry.
 
 Listing this endpoint lists all active or deleted databases that have been
 registered in the cluster so far.
+`
+
+	helpSynopsisAlias = `
+Write, Read, List and Delete cluster aliases.
+`
+
+	helpDescriptionAlias = `
+An alias is a stable name that points to a registered cluster. The alias can be used
+instead of the cluster name to request credentials, to read the cluster, to manage its
+databases and metadata, and as the source of a clone. Consumers that use the alias do not
+need to change their paths when the alias is repointed, for example to a clone restored
+from a snapshot.
+
+Configuring, deleting and purging a cluster always require the name of the cluster, and
+an alias cannot have the name of a registered cluster. A cluster cannot be deleted or
+purged while an alias points to it, repoint or delete the alias first. Leases record the
+name of the cluster they were issued for, so they are renewed and revoked on that cluster
+even after the alias is repointed.
+
+Reading an alias returns the "cluster" it points to and the "previous_cluster" it pointed
+to before it was last repointed.
+`
+
+	helpSynopsisAliasCutover = `
+Repoint an alias from its current cluster to another cluster.
+`
+
+	helpDescriptionAliasCutover = `
+This endpoint repoints the alias to the "target" cluster in a single write, which is
+useful to switch the consumers over to a clone. If "source" is set the cutover fails
+unless the alias still points to that cluster, which protects against concurrent
+cutovers.
+
+When "delete_source" is set to true the cluster that the alias pointed to is marked as
+deleted after the alias is repointed, together with its databases and their metadata.
+The cluster is kept if other aliases still point to it. New credentials cannot be issued
+on the deleted cluster but its existing leases are revoked as usual. Use gc/cluster to
+purge it once the leases have expired.
 `
 
 	helpSynopsisListClusters = `
@@ -58,6 +120,30 @@ snapshot restores or database cloning features provided by most cloud providers.
 Cloning a cluster will first use the source credentials to validate the connection
 with clone endpoint and, if successful, will rotate the password for both root
 and management user. All the other details are kept intact.
+
+Some providers reset the root password when a snapshot is restored. In that case the
+root credentials of the clone can be provided using 'root_username' and 'root_password',
+which are only used if the clone rejects the credentials of the source. The password of
+the override root user is rotated as well, and the management role is reconciled using
+the root connection. It is created again if it does not exist on the clone, and its
+password is rotated otherwise. The passwords of the source are never returned.
+
+The databases to inherit can be selected using 'include' and 'exclude', which accept
+database names with a leading or trailing '*'. Every selected database, and its objects
+owner, must exist on the clone to be inherited. The databases that do not are reported
+as warnings and are not configured in the clone.
+
+When 'dry_run' is set to true the connection with the clone is validated using the
+credentials of the source, and the response reports whether the management role exists
+on the clone and, for every selected database, whether the database and its objects
+owner exist and whether it would be inherited. The databases excluded by the filters
+are listed in 'excluded'. Nothing is changed on the clone and no configuration is stored.
+
+When 'copy_metadata' is set to true the metadata of the source cluster and of every
+inherited database is copied to the clone. The key-value pairs in 'metadata' are set on
+top of the copied metadata of the clone cluster and all its databases. Metadata is not
+copied by default since the copies match the same selectors as the source, which makes
+the selectors that must match a single database ambiguous until the source is deleted.
 `
 
 	helpSynopsisDatabase = `
@@ -66,8 +152,10 @@ Write, Read and Delete database configuration.
 
 	helpDescriptionDatabase = `
 Writing to this endpoint will attempt to create a database with matching name in
-the cluster. The request will fail if the database already exist. It is not possible
-to write to a database in a cluster that is marked 'disabled'.
+the cluster. The request will fail if the database already exist, unless it only
+updates the 'max_credential_ttl' of the database. The new limit applies to credentials
+issued or renewed after the update. It is not possible to write to a database in a
+cluster that is marked 'disabled'.
 
 Vault will create an owner role for each database. This role will ultimately own
 all objects created by temporary users. It is possible to override this behaviour
@@ -75,6 +163,17 @@ using role provided creation statements but keep in mind that if the ownership
 is not transferred and re-assigned properly then the temporary users will not be
 able to use objects created by each other.
 
+The connect privilege on databases created by Vault is revoked from PUBLIC and granted
+to the management role and the objects owner, so only the users that are members of
+the objects owner or are granted connect by the role statements can connect. The
+privileges of databases that already exist are left unchanged.
+
+Registration is tracked in a write-ahead log. If initialization fails after the
+database has been created, Vault drops the database again, but only if it was
+created by Vault, and removes the objects owner role. If the registration is
+interrupted, a retry of the same request picks up the half-initialized database,
+otherwise Vault rolls it back automatically after a while.
+
 This endpoint can not be used to read a delete4d database configuration 
 or a database that exists in deleted cluster.
 
@@ -97,10 +196,172 @@ Write, Read and Delete roles
 A role describes the TTL on credential lease and optionally the queries to create
 and revoke the database users.
 
+By default the statements are executed by the management role of the cluster,
+which can only create and manage roles. If the statements require privileges
+that only a superuser can grant, set 'execute_as' to 'root' to use the root
+connection instead. Only the roles listed in 'root_execution_roles' of the
+config endpoint can use the root connection, and every credential issued this
+way is logged.
+
+When 'assume_objects_owner' is set the user is configured to set the objects owner of
+database as its role on login, so every object created using the credentials is owned by
+the objects owner from the start and nothing has to be reassigned on revocation. The
+membership of the objects owner is verified when the credentials are generated.
+
+By default every lease creates a new database user. When 'user_type' is set to 'entity'
+each Vault entity is mapped to a persistent user named after the entity ID instead. The
+user is created using the 'creation_statement' when it does not exist, and on every lease
+the 'login_statement' enables its login with a fresh password and expiry, which also
+invalidates the password of earlier leases. The default 'revocation_statement' of these
+roles only disables the login, and it is executed when the last lease of the entity is
+revoked. The requested groups and the objects owner of the database are revoked with
+each lease unless another lease of the entity still needs them, so a user does not keep
+access to a database after its leases on that database are revoked. Users of entities
+that are deleted from Vault are dropped periodically once they have no active leases.
+Entity roles must be scoped to a single database.
+
+The 'login_statement' can use the same template variables as the 'creation_statement'.
+
+The 'revocation_mode' decides what happens to the user when a lease is revoked. The
+default 'drop' executes the 'revocation_statement'. With 'nologin' the revocation
+statements are not executed, instead the login and all role memberships of the user are
+removed and the user is kept along with its objects. With 'nologin_then_drop_after' the
+user is disabled the same way and the revocation statements are executed periodically
+once 'revocation_retention' has passed. A disabled user is kept in storage until it is
+dropped, and is reported in the logs if its cluster or database is no longer configured.
+The mode is recorded in the lease when the credentials are generated, so changing it
+does not affect the existing leases.
+
+When 'credential_type' is set to 'client_certificate' the role issues a TLS client
+certificate and private key instead of a password. The common name of the certificate
+is the generated username, so the cluster can authenticate the user using a 'cert' rule
+in pg_hba.conf. Certificates are signed by the 'client_ca_certificate' of the cluster,
+or the one configured on the mount when the cluster does not have its own. The default
+'creation_statement' of these roles creates the user without a password, and the
+'{{password}}' template variable is not available. Certificates expire with the lease
+and cannot be renewed. Client certificate roles must be scoped to a single database.
+
+The 'allowed_groups' parameter lists the Postgres groups that can be requested in
+addition to the memberships granted by the creation statements, see the creds endpoint.
+
+A role can also span multiple databases in a cluster by setting 'databases' to a list of
+database names, 'database_selector' to a selector expression matched against the
+database metadata, see the metadata endpoint, or both. Credentials for these roles are
+generated using the creds/<cluster>/<role> endpoint. When an update switches a role
+between one and multiple databases, the statements that are not part of the update are
+reset to the defaults of the new scope. The 'creation_statement', 'revocation_statement' and
+'renew_statement' of these roles are executed in the maintenance database and can use:
+
+  {{user}} {{password}} {{expiration}} {{group}}
+
+while 'database_creation_statement' and 'database_revocation_statement' are executed
+in every database and can use:
+
+  {{user}} {{database}} {{objects_owner}} {{group}}
+
+Instead of writing the statements by hand a role can declare its 'privileges' as a list
+of entries with following attributes:
+
+  schemas:        names of the schemas to grant the privileges in
+  object_types:   any of 'tables', 'sequences', 'functions' or 'types'
+  privileges:     privileges to grant, such as SELECT, INSERT, USAGE or EXECUTE
+  include_future: if true the privileges are also granted on the objects that are
+                  created by the objects owner in future
+
+The privileges are compiled into the creation and revocation statements of role, or
+the database statements if the role spans multiple databases. The grants and revokes are
+executed as the objects owner, and the revocation statements drop the privileges that are
+left on the user before it is dropped. The users of these roles are not members of
+the objects owner, and the statements cannot be set together with 'privileges'.
+Privileges on types can only be granted on future objects.
+
+Roles are validated when they are written. The 'default_ttl' cannot exceed the
+'max_ttl', creation and revocation statements cannot be empty, and statements
+can only use the template variables that are available to them:
+
+  creation_statement:   {{user}} {{password}} {{expiration}} {{database}} {{objects_owner}} {{group}}
+  revocation_statement: {{user}} {{database}} {{objects_owner}} {{group}}
+  renew_statement:      {{user}} {{expiration}} {{database}} {{objects_owner}} {{group}}
+
+Statements are also checked for unterminated quotes, comments and unbalanced
+parentheses. The statement grammar is only verified when it is executed.
+
+Writing to an existing role only updates the parameters that are present in the
+request, the others keep their current value. Each write creates a new version
+of the role, see roles/:name/versions.
+
+The renew statements are executed when a lease is renewed and default to a single
+query that extends the 'valid until' attribute of the user. Renewal fails if the
+user does not exist in the database anymore.
+
 Creating a new role makes it available to all registered clusters and databases.
 
-Deleting a role does not revoke the credentials derived from it but it does prevent
-lease renewal. All active lease on a role will be revoked on expiry.
+Deleting a role is refused while leases issued from it are outstanding, unless
+the 'force' parameter is set to true. Deleting a role does not revoke the
+credentials derived from it but it does prevent lease renewal. All active lease
+on a role will be revoked on expiry using the revocation statements that were
+captured when the lease was issued.
+`
+
+	helpSynopsisRoleVersions = `
+List and read the retained versions of a role
+`
+
+	helpDescriptionRoleVersions = `
+Every write to a role creates a new version of it. Vault retains the last 10
+versions of each role. Listing this endpoint returns the retained version numbers
+and reading a version returns the role as it was configured at that version.
+
+Each lease records the version of the role it was issued from.
+`
+
+	helpSynopsisRoleRollback = `
+Restore a previous version of a role
+`
+
+	helpDescriptionRoleRollback = `
+Rolling back a role writes the content of a retained version as a new version
+of the role. The version history is kept intact.
+`
+
+	helpSynopsisRoleDryRun = `
+Test the statements of a role against a database
+`
+
+	helpDescriptionRoleDryRun = `
+This endpoint runs the creation statements of a role, verifies that the new user
+can login, and runs the revocation statements against the given cluster and database.
+The response contains the timing and error of each step so that role changes can be
+verified before rollout.
+
+In the default 'rollback' mode all statements run in a single transaction that is
+rolled back at the end. Since the user is not visible outside of the transaction
+no connection is established, the check only verifies that the user is allowed to
+login, has the connect privilege on the database and, for roles that assume the
+objects owner, is a member of the objects owner. The 'pg_hba.conf' rules, connection
+limits and the role settings applied at login are only verified in 'commit' mode.
+
+In 'commit' mode the user is created for real, a connection is established using
+the generated credentials, and the user is then revoked. Use this mode when the
+statements can not run inside a transaction. If a step fails in this mode the user
+may have to be removed manually.
+`
+
+	helpSynopsisCredsBySelector = `
+Generate temporary credentials against a role in the database matched by a selector.
+`
+
+	helpDescriptionCredsBySelector = `
+This endpoint generates temporary credentials the same way as the creds endpoint, but
+the database is found using the "selector" attribute instead of its cluster and name. The
+selector is matched against the metadata of databases, for example 'service=orders,env=prod',
+and must match exactly one active database. The request fails if no database or more than
+one database matches, the error lists the matched databases in the latter case.
+
+Databases and clusters that are marked as deleted are never matched. The response includes
+the "cluster" and "database" that the credentials were issued for.
+
+The "ttl" and "groups" attributes are supported as in the creds endpoint.
 `
 
 	helpSynopsisCreds = `
@@ -113,14 +374,53 @@ for any active database in an active cluster.
 The TTL of the lease, query to create role, grant proper permissions to it, and revoke
 the role on lease expiry is all decided by the role specified in the request.
 
-If a role is deleted while a lease is still active on it, the lease can no longer be
-renewed. In this case the plugin will also use a pre-configured query to revoke the
-lease on expiry. Note that in this situation the plugin will revoke the credentials
+Each lease captures the revocation statements of its role at the time of issue, and
+those statements are used to revoke it. If a role is deleted while a lease is still
+active on it, the lease can no longer be renewed. Leases issued before the statements
+were captured are revoked using a pre-configured query in that case. Note that in this situation the plugin will revoke the credentials
 on best effort basis and if a query fails during cleanup it will be returned as a
 response warning rather than an error. In any case the lease will be revoked by vault.
 
+Additional Postgres groups can be requested using the 'groups' parameter. Every group
+must be listed in the 'allowed_groups' of role and exist in the cluster. The memberships
+are granted after the creation statements are executed and revoked before the
+revocation statements are executed.
+
+Passwords are never sent to the cluster in plaintext. The '{{password}}' template
+variable holds a SCRAM-SHA-256 or md5 verifier computed by the plugin according to the
+'password_encryption' setting of the server, so it can only be used where Postgres
+accepts a password. When the connection is allowed to change the logging settings, the
+statement logging is also disabled for the transaction that sets the password.
+
+The lease TTL defaults to the 'default_ttl' of role. A different TTL can be requested
+by writing to this endpoint with the 'ttl' parameter. The requested TTL is capped by the
+'max_ttl' of role, the 'max_credential_ttl' configured on the cluster and the database,
+and the maximum lease TTL of the mount, whichever is lowest. The 'valid until' attribute
+of the database user is always computed in UTC.
+
 If the 'creation_statements' and 'revocation_statements' parameters are left empty then
 the plugin will use following queries to create and drop users.
+`
+
+	helpSynopsisClusterCreds = `
+Generate temporary credential pair that can connect with multiple databases in a cluster.
+`
+
+	helpDescriptionClusterCreds = `
+This endpoint is used to generate temporary credentials that can connect with several
+databases in the same cluster. The role must set 'databases', 'database_selector', or
+both to select the databases.
+
+The user is created in the maintenance database of cluster using the 'creation_statement'
+of role, then the 'database_creation_statement' is executed in every selected database.
+The databases are resolved when the credentials are generated and the list is returned
+in the response. Databases that are listed on the role must be available, disabled
+databases matched by the selector are skipped.
+
+On revocation the 'database_revocation_statement' is executed in every database before
+the user is dropped using the 'revocation_statement' in the maintenance database.
+
+The lease TTL is capped by the 'max_credential_ttl' of every selected database.
 `
 
 	helpSynopsisMetadata = `Attach arbitrary key-value pairs to cluster or database object`
@@ -141,11 +441,81 @@ only and when both "cluster" and "database" attributes are specified the metadat
 associated with the database only.
 Databases do not inherit the metadata from their prent cluster.
 
+By default a write replaces all metadata of the object with "data". When "mode" is set to
+"patch" only the keys in "data" are added or updated and the keys in "remove_keys" are
+removed, the other keys are left unchanged. Every write increments the version of the
+metadata, which is returned by writes and lookups. Setting "cas" to the version that was
+read makes the write fail if another writer updated the metadata in the meantime, and
+setting it to 0 makes the write fail if the metadata already exists. Deleting the metadata
+accepts "cas" as well.
+
 When reading from metadata endpoint "lookup" attribute is required and must be set to either
 "database" or "cluster". A positive lookup requires all provided attributes to match, that means
 you can perform lookups using a subset of metadata but not using a superset.
 
+Lookups can also use the "selector" attribute, a comma separated list of requirements that
+must all be satisfied, similar to the label selectors of Kubernetes:
+
+  key=value           the key exists and has the value
+  key!=value          the key does not exist or has another value
+  key in (v1, v2)     the key exists and has one of the values
+  key notin (v1, v2)  the key does not exist or has none of the values
+  key                 the key exists
+  !key                the key does not exist
+
+When both "data" and "selector" are provided the object must satisfy both. The lookup
+returns the names of matched objects along with their metadata ID, metadata and status,
+which is either "active", "deleted" or "missing". Registered objects without metadata are
+matched as if their metadata was empty, so they only match negative requirements.
+
+The metadata of a deleted cluster or database is marked as deleted and is not matched by
+lookups unless "include_deleted" is set. Purging the object using the gc endpoints also
+deletes its metadata, purging a cluster deletes the metadata of all its databases.
+
 Listing metadata endpoint returns a map from object identifier to map of key-values.
+
+The metadata of a cluster is identified by "cluster/<cluster>" and the metadata of a
+database by "database/<cluster>/<database>". Database metadata used to be identified by
+"database/<cluster>-<database>", which is ambiguous when names contain a hyphen. The
+existing entries are migrated when the plugin starts, and deleting metadata using an ID
+in the old format is still supported as long as it identifies a single database.
+`
+
+	helpSynopsisMetadataCheck = `Check the metadata for objects that do not exist anymore`
+
+	helpDescriptionMetadataCheck = `
+Reading this endpoint reports the IDs of metadata attached to clusters or databases that
+are not registered anymore as "missing", and the IDs of metadata whose deleted mark does
+not match the status of its object as "mismatched".
+
+Writing to this endpoint performs the same check and repairs the metadata, the missing
+metadata is deleted and the deleted mark of mismatched metadata is corrected.
+
+The check also reports the metadata that violates the configured metadata schemas as
+"schema_violations", a map from metadata ID to the list of violations. These entries are
+not repaired, they must be corrected by writing the metadata again.
+`
+
+	helpSynopsisMetadataSchema = `Configure the schema of cluster or database metadata`
+
+	helpDescriptionMetadataSchema = `
+This endpoint configures the schema that the metadata of clusters or databases must
+satisfy. The schema is enforced on every metadata write, writes that violate it are
+rejected with the list of violations.
+
+"required_keys" lists the keys that must be present in the metadata. "keys" maps a key
+to its constraints:
+
+  allowed_values  list of values the key can have
+  pattern         regular expression that must match the whole value
+  immutable       if true the value cannot be changed or removed once it is set, and the
+                  metadata cannot be deleted until its cluster or database is purged
+
+If "allow_unknown_keys" is false the metadata cannot contain keys that are not declared
+in "keys".
+
+Configuring a schema does not change the existing metadata. Writing or reading the schema
+returns the IDs of existing metadata that violate it as "violations".
 `
 
 	helpSynopsisGCListClusters = "List all clusters that are deleted and ready for GC"
@@ -175,7 +545,8 @@ When a cluster is purged, all of its databases are also purged from vault.
 Note that vault does not attempt to drop the databases from the physical postgres
 cluster, it only deletes the configuration from its own storage.
 
-A cluster can only be deleted using this endpoint if it is marked as deleted.
+A cluster can only be deleted using this endpoint if it is marked as deleted and
+none of its users disabled on revocation is waiting to be dropped.
 `
 
 	helpSynopsisGCDbOps = "Read and delete database configuration from a cluster"
@@ -191,6 +562,7 @@ Deleting from this endpoint deleted the database configuration from vault
 storage. Note that vault does not attempt to drop the database from physical
 postgres cluster, it only deleted the configuration from its own storage.
 
-A database can only be deleted using this endpoint if it is marked as deleted.
+A database can only be deleted using this endpoint if it is marked as deleted and
+none of its users disabled on revocation is waiting to be dropped.
 `
 )
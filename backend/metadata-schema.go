@@ -0,0 +1,305 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+// MetadataSchema constrains the metadata of one object type
+type MetadataSchema struct {
+	RequiredKeys     []string                     `json:"required_keys" mapstructure:"required_keys"`
+	AllowUnknownKeys bool                         `json:"allow_unknown_keys" mapstructure:"allow_unknown_keys"`
+	Keys             map[string]MetadataKeySchema `json:"keys" mapstructure:"keys"`
+}
+
+// MetadataKeySchema constrains the values of a metadata key. The value must
+// be one of the allowed values if any are set, and must match the pattern.
+// Immutable keys cannot be changed or removed once they are set.
+type MetadataKeySchema struct {
+	AllowedValues []string `json:"allowed_values" mapstructure:"allowed_values"`
+	Pattern       string   `json:"pattern" mapstructure:"pattern"`
+	Immutable     bool     `json:"immutable" mapstructure:"immutable"`
+}
+
+func (s *MetadataSchema) AsMap() map[string]interface{} {
+	keys := make(map[string]interface{}, len(s.Keys))
+	for k, v := range s.Keys {
+		keys[k] = map[string]interface{}{
+			"allowed_values": v.AllowedValues,
+			"pattern":        v.Pattern,
+			"immutable":      v.Immutable,
+		}
+	}
+
+	return map[string]interface{}{
+		"required_keys":      s.RequiredKeys,
+		"allow_unknown_keys": s.AllowUnknownKeys,
+		"keys":               keys,
+	}
+}
+
+func (s *MetadataSchema) loadFromFields(data *framework.FieldData) error {
+	for k := range data.Schema {
+		v, ok := data.GetOk(k)
+		if !ok {
+			v = data.Get(k)
+		}
+
+		switch k {
+		case "required_keys":
+			s.RequiredKeys = strutil.RemoveDuplicates(v.([]string), false)
+		case "allow_unknown_keys":
+			s.AllowUnknownKeys = v.(bool)
+		case "keys":
+			keys := map[string]MetadataKeySchema{}
+			if err := mapstructure.WeakDecode(v, &keys); err != nil {
+				return fmt.Errorf("invalid keys: %s", err)
+			}
+			s.Keys = keys
+		}
+	}
+
+	return s.validate()
+}
+
+func (s *MetadataSchema) validate() error {
+	for _, k := range s.RequiredKeys {
+		if strings.TrimSpace(k) == "" {
+			return fmt.Errorf("required_keys cannot contain an empty key")
+		}
+
+		if !s.AllowUnknownKeys {
+			if _, ok := s.Keys[k]; !ok {
+				return fmt.Errorf("required key %q must be declared in keys when unknown keys are not allowed", k)
+			}
+		}
+	}
+
+	for k, v := range s.Keys {
+		if strings.TrimSpace(k) == "" {
+			return fmt.Errorf("keys cannot contain an empty key")
+		}
+
+		if v.Pattern != "" {
+			if _, err := compileMetadataPattern(v.Pattern); err != nil {
+				return fmt.Errorf("invalid pattern for key %q: %s", k, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// compileMetadataPattern compiles a pattern that must match the whole value
+func compileMetadataPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// Violations returns the reasons the metadata does not satisfy the schema.
+// The existing metadata of the object is used to enforce immutable keys,
+// it is nil when the metadata is written for the first time.
+func (s *MetadataSchema) Violations(data, existing map[string]string) []string {
+	var violations []string
+	for _, k := range s.RequiredKeys {
+		if _, ok := data[k]; !ok {
+			violations = append(violations, fmt.Sprintf("required key %q is missing", k))
+		}
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := data[k]
+		ks, ok := s.Keys[k]
+		if !ok {
+			if !s.AllowUnknownKeys {
+				violations = append(violations, fmt.Sprintf("key %q is not allowed", k))
+			}
+			continue
+		}
+
+		if len(ks.AllowedValues) > 0 && !strutil.StrListContains(ks.AllowedValues, v) {
+			violations = append(violations, fmt.Sprintf("value %q of key %q is not one of %s", v, k, strings.Join(ks.AllowedValues, ", ")))
+		}
+
+		if ks.Pattern != "" {
+			// The pattern is validated when the schema is written
+			re, err := compileMetadataPattern(ks.Pattern)
+			if err == nil && !re.MatchString(v) {
+				violations = append(violations, fmt.Sprintf("value %q of key %q does not match %q", v, k, ks.Pattern))
+			}
+		}
+	}
+
+	for k, ks := range s.Keys {
+		if !ks.Immutable || existing == nil {
+			continue
+		}
+
+		old, ok := existing[k]
+		if !ok {
+			continue
+		}
+
+		if v, ok := data[k]; !ok || v != old {
+			violations = append(violations, fmt.Sprintf("key %q is immutable and cannot be changed from %q", k, old))
+		}
+	}
+
+	return violations
+}
+
+// ImmutableKeys returns the sorted immutable keys that are set in data
+func (s *MetadataSchema) ImmutableKeys(data map[string]string) []string {
+	var keys []string
+	for k, ks := range s.Keys {
+		if _, ok := data[k]; ok && ks.Immutable {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// loadMetadataSchema returns nil if no schema is configured for the type
+func loadMetadataSchema(ctx context.Context, storage logical.Storage, target string) (*MetadataSchema, error) {
+	entry, err := storage.Get(ctx, PathMetaSchema.For(target))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	s := &MetadataSchema{}
+	err = entry.DecodeJSON(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func storeMetadataSchema(ctx context.Context, storage logical.Storage, target string, s *MetadataSchema) error {
+	entry, err := logical.StorageEntryJSON(PathMetaSchema.For(target), s)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// metadataSchemaViolations returns the violations of the existing metadata
+// of the object type, keyed by the metadata ID.
+func metadataSchemaViolations(ctx context.Context, storage logical.Storage, target string, s *MetadataSchema) (map[string][]string, error) {
+	ids, err := listMetadataIDs(ctx, storage, target)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string][]string{}
+	for _, id := range ids {
+		m, err := loadMetadataEntry(ctx, storage, PathMeta.For(id))
+		if err == ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if v := s.Violations(m.Data, nil); len(v) > 0 {
+			result[id] = v
+		}
+	}
+
+	return result, nil
+}
+
+func validMetadataType(target string) bool {
+	return target == "cluster" || target == "database"
+}
+
+func (b *backend) pathMetadataSchemaRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	target := data.Get("type").(string)
+	if !validMetadataType(target) {
+		return logical.ErrorResponse(fmt.Sprintf("invalid type %q, only 'cluster' or 'database' is supported", target)), nil
+	}
+
+	s, err := loadMetadataSchema(ctx, req.Storage, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		return nil, nil
+	}
+
+	violations, err := metadataSchemaViolations(ctx, req.Storage, target, s)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: s.AsMap(),
+	}
+	resp.Data["violations"] = violations
+
+	return resp, nil
+}
+
+func (b *backend) pathMetadataSchemaUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	target := data.Get("type").(string)
+	if !validMetadataType(target) {
+		return logical.ErrorResponse(fmt.Sprintf("invalid type %q, only 'cluster' or 'database' is supported", target)), nil
+	}
+
+	s := &MetadataSchema{}
+	if err := s.loadFromFields(data); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := storeMetadataSchema(ctx, req.Storage, target, s); err != nil {
+		return nil, err
+	}
+
+	violations, err := metadataSchemaViolations(ctx, req.Storage, target, s)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"violations": violations,
+		},
+	}
+
+	if len(violations) > 0 {
+		resp.AddWarning(fmt.Sprintf("The metadata of %d existing objects violates the schema, see 'violations'", len(violations)))
+	}
+
+	return resp, nil
+}
+
+func (b *backend) pathMetadataSchemaDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	target := data.Get("type").(string)
+	if err := req.Storage.Delete(ctx, PathMetaSchema.For(target)); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{}, nil
+}
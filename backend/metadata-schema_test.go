@@ -0,0 +1,196 @@
+package backend
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMetadataSchema_validate(t *testing.T) {
+	cases := map[string]struct {
+		schema *MetadataSchema
+		valid  bool
+	}{
+		"empty": {
+			schema: &MetadataSchema{AllowUnknownKeys: true},
+			valid:  true,
+		},
+		"valid pattern": {
+			schema: &MetadataSchema{Keys: map[string]MetadataKeySchema{"owner": {Pattern: "[a-z]+@example\\.com"}}},
+			valid:  true,
+		},
+		"invalid pattern": {
+			schema: &MetadataSchema{Keys: map[string]MetadataKeySchema{"owner": {Pattern: "[a-z"}}},
+		},
+		"empty required key": {
+			schema: &MetadataSchema{AllowUnknownKeys: true, RequiredKeys: []string{""}},
+		},
+		"undeclared required key": {
+			schema: &MetadataSchema{RequiredKeys: []string{"env"}},
+		},
+		"declared required key": {
+			schema: &MetadataSchema{RequiredKeys: []string{"env"}, Keys: map[string]MetadataKeySchema{"env": {}}},
+			valid:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		err := tc.schema.validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: expected schema to be valid, got %s", name, err)
+		}
+
+		if !tc.valid && err == nil {
+			t.Errorf("%s: expected schema to be invalid", name)
+		}
+	}
+}
+
+func TestMetadataSchema_violations(t *testing.T) {
+	schema := &MetadataSchema{
+		RequiredKeys:     []string{"env", "owner"},
+		AllowUnknownKeys: true,
+		Keys: map[string]MetadataKeySchema{
+			"env":   {AllowedValues: []string{"prod", "staging"}, Immutable: true},
+			"owner": {Pattern: "[a-z]+"},
+		},
+	}
+
+	cases := map[string]struct {
+		data     map[string]string
+		existing map[string]string
+		expect   int
+	}{
+		"valid":               {data: map[string]string{"env": "prod", "owner": "payments", "extra": "x"}},
+		"missing key":         {data: map[string]string{"env": "prod"}, expect: 1},
+		"value not allowed":   {data: map[string]string{"env": "dev", "owner": "payments"}, expect: 1},
+		"partial pattern":     {data: map[string]string{"env": "prod", "owner": "payments-1"}, expect: 1},
+		"immutable unchanged": {data: map[string]string{"env": "prod", "owner": "search"}, existing: map[string]string{"env": "prod"}},
+		"immutable changed":   {data: map[string]string{"env": "staging", "owner": "search"}, existing: map[string]string{"env": "prod"}, expect: 1},
+		"immutable removed":   {data: map[string]string{"owner": "search"}, existing: map[string]string{"env": "prod"}, expect: 2},
+	}
+
+	for name, tc := range cases {
+		v := schema.Violations(tc.data, tc.existing)
+		if len(v) != tc.expect {
+			t.Errorf("%s: expected %d violations, got %+v", name, tc.expect, v)
+		}
+	}
+
+	schema.AllowUnknownKeys = false
+	if v := schema.Violations(map[string]string{"env": "prod", "owner": "payments", "extra": "x"}, nil); len(v) != 1 {
+		t.Errorf("expected unknown key to be rejected, got %+v", v)
+	}
+}
+
+func TestMetadataSchema_enforced(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	if err := storeClusterEntry(ctx, storage, testCluster, &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"}); err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	// Metadata written before the schema is reported as a violation
+	meta := &Metadata{Cluster: testCluster, Data: map[string]string{"team": "payments"}}
+	if err := storeMetadataEntry(ctx, storage, PathMeta.For(clusterMetadataID(testCluster)), meta); err != nil {
+		t.Fatalf("failed to store metadata. %s", err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "metadata-schema/cluster",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"required_keys": "env",
+			"keys": map[string]interface{}{
+				"env": map[string]interface{}{
+					"allowed_values": []string{"prod", "staging"},
+					"immutable":      true,
+				},
+			},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write schema. err: %v, resp: %v", err, resp)
+	}
+
+	violations := resp.Data["violations"].(map[string][]string)
+	if _, ok := violations[clusterMetadataID(testCluster)]; !ok || len(resp.Warnings) == 0 {
+		t.Fatalf("expected existing metadata to be reported, got %+v", resp)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata/check",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to check metadata. err: %v, resp: %v", err, resp)
+	}
+
+	if !reflect.DeepEqual(violations, resp.Data["schema_violations"]) {
+		t.Fatalf("expected %+v to match %+v", violations, resp.Data["schema_violations"])
+	}
+
+	writes := []struct {
+		data  []string
+		valid bool
+	}{
+		{data: []string{"team=payments"}, valid: false},
+		{data: []string{"env=dev"}, valid: false},
+		{data: []string{"env=prod"}, valid: true},
+		{data: []string{"env=staging"}, valid: false},
+		{data: []string{"env=prod", "team=search"}, valid: true},
+	}
+
+	for _, w := range writes {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "metadata",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"cluster": testCluster,
+				"data":    w.data,
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to write metadata. %s", err)
+		}
+
+		if w.valid == (resp != nil && resp.IsError()) {
+			t.Fatalf("%v: expected valid=%t, got %+v", w.data, w.valid, resp)
+		}
+	}
+
+	// Immutable keys cannot be reset by deleting and writing the metadata again
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/" + clusterMetadataID(testCluster),
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatalf("failed to delete metadata. %s", err)
+	}
+
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "immutable keys env") {
+		t.Fatalf("expected delete to be refused, got %+v", resp)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata-schema/cluster",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete schema. err: %v, resp: %v", err, resp)
+	}
+
+	if s, err := loadMetadataSchema(ctx, storage, "cluster"); err != nil || s != nil {
+		t.Fatalf("expected schema to be deleted, got %+v. err: %v", s, err)
+	}
+}
@@ -27,6 +27,13 @@ var propsPathInfo = framework.OperationProperties{
 	Description: helpDescriptionInfo,
 }
 
+var propsConfigRead = framework.OperationProperties{
+	Summary:     helpSynopsisConfig,
+	Description: helpDescriptionConfig,
+}
+
+var propsConfigUpdate = propsConfigRead
+
 var propsMetadataUpdate = framework.OperationProperties{
 	Summary:     helpSynopsisMetadata,
 	Description: helpDescriptionMetadata,
@@ -38,6 +45,38 @@ var propsMetadataList = propsMetadataUpdate
 
 var propsMetadataDelete = propsMetadataUpdate
 
+var propsMetadataCheckRead = framework.OperationProperties{
+	Summary:     helpSynopsisMetadataCheck,
+	Description: helpDescriptionMetadataCheck,
+}
+
+var propsMetadataCheckUpdate = propsMetadataCheckRead
+
+var propsMetadataSchemaRead = framework.OperationProperties{
+	Summary:     helpSynopsisMetadataSchema,
+	Description: helpDescriptionMetadataSchema,
+}
+
+var propsMetadataSchemaUpdate = propsMetadataSchemaRead
+
+var propsMetadataSchemaDelete = propsMetadataSchemaRead
+
+var propsAliasesList = framework.OperationProperties{
+	Summary:     helpSynopsisAlias,
+	Description: helpDescriptionAlias,
+}
+
+var propsAliasRead = propsAliasesList
+
+var propsAliasUpdate = propsAliasesList
+
+var propsAliasDelete = propsAliasesList
+
+var propsAliasCutover = framework.OperationProperties{
+	Summary:     helpSynopsisAliasCutover,
+	Description: helpDescriptionAliasCutover,
+}
+
 var propsClustersList = framework.OperationProperties{
 	Summary:     helpSynopsisListClusters,
 	Description: helpDescriptionListClusters,
@@ -79,11 +118,44 @@ var propsRoleRead = propsRoleUpdate
 
 var propsRoleDelete = propsRoleUpdate
 
+var propsRoleVersionsList = framework.OperationProperties{
+	Summary:     helpSynopsisRoleVersions,
+	Description: helpDescriptionRoleVersions,
+}
+
+var propsRoleVersionRead = propsRoleVersionsList
+
+var propsRoleRollback = framework.OperationProperties{
+	Summary:     helpSynopsisRoleRollback,
+	Description: helpDescriptionRoleRollback,
+}
+
+var propsRoleDryRun = framework.OperationProperties{
+	Summary:     helpSynopsisRoleDryRun,
+	Description: helpDescriptionRoleDryRun,
+}
+
 var propsCredsRead = framework.OperationProperties{
 	Summary:     helpSynopsisCreds,
 	Description: helpDescriptionCreds,
 }
 
+var propsCredsUpdate = propsCredsRead
+
+var propsCredsBySelectorRead = framework.OperationProperties{
+	Summary:     helpSynopsisCredsBySelector,
+	Description: helpDescriptionCredsBySelector,
+}
+
+var propsCredsBySelectorUpdate = propsCredsBySelectorRead
+
+var propsClusterCredsRead = framework.OperationProperties{
+	Summary:     helpSynopsisClusterCreds,
+	Description: helpDescriptionClusterCreds,
+}
+
+var propsClusterCredsUpdate = propsClusterCredsRead
+
 var propsGcListClusters = framework.OperationProperties{
 	Summary:     helpSynopsisGCListClusters,
 	Description: helpDescriptionGCListClusters,
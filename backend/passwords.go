@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	queryPasswordEncryption = `show password_encryption`
+
+	// Statements that stop the server from logging the statements of the
+	// current transaction. They require superuser or the SET privilege.
+	querySavepointLogging  = `savepoint vault_statement_logging`
+	queryDisableLogging    = `set local log_statement = 'none'`
+	queryDisableDurations  = `set local log_min_duration_statement = -1`
+	queryReleaseLogging    = `release savepoint vault_statement_logging`
+	queryRollbackToLogging = `rollback to savepoint vault_statement_logging`
+)
+
+// Same defaults as the server uses when it hashes a password
+const (
+	scramIterations = 4096
+	scramSaltLength = 16
+)
+
+var md5VerifierRegex = regexp.MustCompile(`^md5[0-9a-f]{32}$`)
+
+// isPasswordVerifier returns true if the password is already hashed
+func isPasswordVerifier(password string) bool {
+	return strings.HasPrefix(password, "SCRAM-SHA-256$") || md5VerifierRegex.MatchString(password)
+}
+
+// protectPassword keeps the password of user out of the server logs. The
+// statement logging is disabled for the transaction when the connection is
+// allowed to do so, and the password in template is replaced with a verifier
+// hashed using the password_encryption of the server. The server stores the
+// verifier as it is, so the user can still login with the plaintext password.
+func protectPassword(ctx context.Context, tx *sql.Tx, m map[string]string) error {
+	password := m["password"]
+	if password == "" {
+		return nil
+	}
+
+	disableStatementLogging(ctx, tx)
+	if isPasswordVerifier(password) {
+		return nil
+	}
+
+	var encryption string
+	if err := tx.QueryRowContext(ctx, queryPasswordEncryption).Scan(&encryption); err != nil {
+		return err
+	}
+
+	verifier, err := passwordVerifier(encryption, unquoteIdentifier(m["user"]), password)
+	if err != nil {
+		return err
+	}
+
+	m["password"] = verifier
+	return nil
+}
+
+// disableStatementLogging disables the statement logging for the rest of the
+// transaction. A savepoint keeps the transaction usable if it is not allowed.
+func disableStatementLogging(ctx context.Context, tx *sql.Tx) {
+	if _, err := tx.ExecContext(ctx, querySavepointLogging); err != nil {
+		return
+	}
+
+	for _, query := range []string{queryDisableLogging, queryDisableDurations} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			_, _ = tx.ExecContext(ctx, queryRollbackToLogging)
+			break
+		}
+	}
+
+	_, _ = tx.ExecContext(ctx, queryReleaseLogging)
+}
+
+// passwordVerifier hashes the password the same way the server would for the
+// given password_encryption setting. Servers older than 10 only support md5.
+func passwordVerifier(encryption, username, password string) (string, error) {
+	if strings.ToLower(encryption) != "scram-sha-256" {
+		return md5Verifier(username, password), nil
+	}
+
+	salt := make([]byte, scramSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return scramVerifier(password, salt, scramIterations), nil
+}
+
+func md5Verifier(username, password string) string {
+	sum := md5.Sum([]byte(password + username))
+	return "md5" + hex.EncodeToString(sum[:])
+}
+
+// scramVerifier returns the SCRAM-SHA-256 verifier in the format stored in
+// pg_authid. The password is not normalized with SASLprep, which leaves the
+// generated passwords unchanged since they are plain ASCII.
+func scramVerifier(password string, salt []byte, iterations int) string {
+	salted := pbkdf2SHA256([]byte(password), salt, iterations)
+
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := hmacSHA256(salted, []byte("Server Key"))
+
+	enc := base64.StdEncoding
+	return fmt.Sprintf("SCRAM-SHA-256$%d:%s$%s:%s", iterations,
+		enc.EncodeToString(salt), enc.EncodeToString(storedKey[:]), enc.EncodeToString(serverKey))
+}
+
+// pbkdf2SHA256 derives a single block key, which is all SCRAM-SHA-256 needs
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	block := make([]byte, 4)
+	binary.BigEndian.PutUint32(block, 1)
+
+	u := hmacSHA256(password, append(append([]byte{}, salt...), block...))
+	result := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		u = hmacSHA256(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+
+	return result
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// unquoteIdentifier reverses pq.QuoteIdentifier
+func unquoteIdentifier(s string) string {
+	if len(s) < 2 || !strings.HasPrefix(s, `"`) || !strings.HasSuffix(s, `"`) {
+		return s
+	}
+
+	return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+}
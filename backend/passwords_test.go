@@ -0,0 +1,46 @@
+package backend
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestPasswordVerifier(t *testing.T) {
+	derived := hex.EncodeToString(pbkdf2SHA256([]byte("password"), []byte("salt"), 4096))
+	if derived != "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a" {
+		t.Fatalf("unexpected pbkdf2 key %s", derived)
+	}
+
+	scram := scramVerifier("secret", []byte("0123456789abcdef"), scramIterations)
+	expected := "SCRAM-SHA-256$4096:MDEyMzQ1Njc4OWFiY2RlZg==$bpSY5Ze9NUH+I35LC3gVq+DpBfK46iXBxvhAKqVu9pE=:VpYlBuxyzeCI1KnctrefdljpB1mk3Gp7sBI/t11+NkQ="
+	if scram != expected {
+		t.Fatalf("unexpected scram verifier %s", scram)
+	}
+
+	md5, err := passwordVerifier("md5", "v-token-user", "secret")
+	if err != nil || md5 != "md5749fbd932e28300c21977139b68fb0a4" {
+		t.Fatalf("unexpected md5 verifier %s. err: %v", md5, err)
+	}
+
+	generated, err := passwordVerifier("scram-sha-256", "v-token-user", "secret")
+	if err != nil || !strings.HasPrefix(generated, "SCRAM-SHA-256$4096:") {
+		t.Fatalf("expected scram verifier, got %s. err: %v", generated, err)
+	}
+
+	for _, v := range []string{scram, md5, generated} {
+		if !isPasswordVerifier(v) {
+			t.Errorf("expected %s to be detected as a verifier", v)
+		}
+	}
+
+	if isPasswordVerifier("A1a-secret") {
+		t.Errorf("expected plaintext password not to be detected as a verifier")
+	}
+
+	if u := unquoteIdentifier(pq.QuoteIdentifier(`v-token-"quoted"`)); u != `v-token-"quoted"` {
+		t.Errorf("unexpected unquoted identifier %s", u)
+	}
+}
@@ -0,0 +1,310 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// ClusterAlias is a stable name that points to a registered cluster. It can
+// be used in place of the cluster name, so consumers do not need to change
+// their paths when the alias is repointed to a clone.
+type ClusterAlias struct {
+	Cluster         string `json:"cluster"`
+	PreviousCluster string `json:"previous_cluster"`
+}
+
+func (a *ClusterAlias) AsMap() map[string]interface{} {
+	return map[string]interface{}{
+		"cluster":          a.Cluster,
+		"previous_cluster": a.PreviousCluster,
+	}
+}
+
+func loadClusterAlias(ctx context.Context, storage logical.Storage, name string) (*ClusterAlias, error) {
+	entry, err := storage.Get(ctx, PathAlias.For(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+
+	a := &ClusterAlias{}
+	err = entry.DecodeJSON(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func storeClusterAlias(ctx context.Context, storage logical.Storage, name string, a *ClusterAlias) error {
+	entry, err := logical.StorageEntryJSON(PathAlias.For(name), a)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// resolveClusterName returns the name of the cluster that the alias points
+// to. Names that are not aliases are returned unchanged, so the callers
+// report unknown clusters the same way as before.
+func resolveClusterName(ctx context.Context, storage logical.Storage, name string) (string, error) {
+	if name == "" {
+		return name, nil
+	}
+
+	a, err := loadClusterAlias(ctx, storage, name)
+	if err == ErrNotFound {
+		return name, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return a.Cluster, nil
+}
+
+// checkNotAlias returns an error response if name is used by an alias
+func checkNotAlias(ctx context.Context, storage logical.Storage, name string) (*logical.Response, error) {
+	a, err := loadClusterAlias(ctx, storage, name)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ErrorResponse(fmt.Sprintf("%s is an alias of cluster %s. Use the name of the cluster instead", name, a.Cluster)), nil
+}
+
+// clusterAliases returns the names of aliases that point to the cluster
+func clusterAliases(ctx context.Context, storage logical.Storage, clusterName string) ([]string, error) {
+	names, err := storage.List(ctx, PathAlias.For(""))
+	if err != nil {
+		return nil, err
+	}
+
+	var aliases []string
+	for _, name := range names {
+		a, err := loadClusterAlias(ctx, storage, name)
+		if err == ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if a.Cluster == clusterName {
+			aliases = append(aliases, name)
+		}
+	}
+
+	return aliases, nil
+}
+
+// checkNotAliasTarget returns an error response if any alias points to the
+// cluster, so that removing the cluster does not leave the aliases dangling
+func checkNotAliasTarget(ctx context.Context, storage logical.Storage, clusterName string) (*logical.Response, error) {
+	aliases, err := clusterAliases(ctx, storage, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(aliases) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is the target of aliases %s. Repoint or delete the aliases first", clusterName, strings.Join(aliases, ", "))), nil
+	}
+
+	return nil, nil
+}
+
+// loadAliasTarget returns an error response if the cluster cannot be the
+// target of an alias
+func loadAliasTarget(ctx context.Context, storage logical.Storage, clusterName string) (*logical.Response, error) {
+	if clusterName == "" {
+		return logical.ErrorResponse("'cluster' attribute is required"), nil
+	}
+
+	c, err := loadClusterEntry(ctx, storage, clusterName)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Cluster with name %s is not registered", clusterName)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.IsDisabled() {
+		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is deleted and cannot be the target of an alias", clusterName)), nil
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathAliasesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, PathAlias.For(""))
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathAliasRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("alias").(string)
+	a, err := loadClusterAlias(ctx, req.Storage, name)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: a.AsMap(),
+	}, nil
+}
+
+func (b *backend) pathAliasUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("alias").(string)
+	clusterName := data.Get("cluster").(string)
+
+	b.aliasLock.Lock()
+	defer b.aliasLock.Unlock()
+
+	_, err := loadClusterEntry(ctx, req.Storage, name)
+	if err == nil {
+		return logical.ErrorResponse(fmt.Sprintf("A cluster with name %s is already configured, aliases cannot shadow clusters", name)), nil
+	}
+
+	if err != ErrNotFound {
+		return nil, err
+	}
+
+	resp, err := loadAliasTarget(ctx, req.Storage, clusterName)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	a, err := loadClusterAlias(ctx, req.Storage, name)
+	if err == ErrNotFound {
+		a = &ClusterAlias{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if a.Cluster != clusterName {
+		a.PreviousCluster = a.Cluster
+		a.Cluster = clusterName
+	}
+
+	err = storeClusterAlias(ctx, req.Storage, name, a)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: a.AsMap(),
+	}, nil
+}
+
+func (b *backend) pathAliasDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("alias").(string)
+	err := req.Storage.Delete(ctx, PathAlias.For(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{}, nil
+}
+
+// pathAliasCutover repoints the alias from its current cluster to the target
+// and optionally marks the previous cluster as deleted. The alias is only
+// repointed if it still points to the expected source cluster.
+func (b *backend) pathAliasCutover(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("alias").(string)
+	targetName := data.Get("target").(string)
+	deleteSource := data.Get("delete_source").(bool)
+
+	b.aliasLock.Lock()
+	defer b.aliasLock.Unlock()
+
+	a, err := loadClusterAlias(ctx, req.Storage, name)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Alias %s is not configured", name)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sourceName := a.Cluster
+	if expected, ok := data.GetOk("source"); ok && expected.(string) != sourceName {
+		return logical.ErrorResponse(fmt.Sprintf("Alias %s points to cluster %s instead of %s", name, sourceName, expected)), nil
+	}
+
+	if targetName == sourceName {
+		return logical.ErrorResponse(fmt.Sprintf("Alias %s already points to cluster %s", name, targetName)), nil
+	}
+
+	resp, err := loadAliasTarget(ctx, req.Storage, targetName)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	var source *ClusterConfig
+	if deleteSource {
+		source, err = loadClusterEntry(ctx, req.Storage, sourceName)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+	}
+
+	a.PreviousCluster = sourceName
+	a.Cluster = targetName
+	err = storeClusterAlias(ctx, req.Storage, name, a)
+	if err != nil {
+		return nil, err
+	}
+
+	resp = &logical.Response{
+		Data: a.AsMap(),
+	}
+
+	if !deleteSource {
+		return resp, nil
+	}
+
+	if source == nil || source.IsDisabled() {
+		resp.AddWarning(fmt.Sprintf("Source cluster %s is not registered or already deleted", sourceName))
+		return resp, nil
+	}
+
+	aliases, err := clusterAliases(ctx, req.Storage, sourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(aliases) > 0 {
+		resp.AddWarning(fmt.Sprintf("Source cluster %s is not deleted, it is the target of aliases %s", sourceName, strings.Join(aliases, ", ")))
+		return resp, nil
+	}
+
+	err = b.disableCluster(ctx, req.Storage, sourceName, source)
+	if err != nil {
+		return nil, fmt.Errorf("alias %s was repointed to %s but failed to delete source cluster %s. %s", name, targetName, sourceName, err)
+	}
+
+	resp.AddWarning(fmt.Sprintf("Source cluster %s is marked as deleted. Use gc/cluster to manage deleted clusters", sourceName))
+	return resp, nil
+}
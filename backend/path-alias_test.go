@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestClusterAlias_cutover(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	const alias = "orders-primary"
+	for _, cluster := range []string{testCluster, "clone"} {
+		if err := storeClusterEntry(ctx, storage, cluster, &ClusterConfig{Host: cluster, Port: 5432, Database: "postgres"}); err != nil {
+			t.Fatalf("failed to store cluster. %s", err)
+		}
+
+		if err := storeDbEntry(ctx, storage, cluster, testDb, &DbConfig{Cluster: cluster, Database: testDb, ObjectsOwner: "owner"}); err != nil {
+			t.Fatalf("failed to store database. %s", err)
+		}
+	}
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatalf("%s %s: unexpected error. %s", op, path, err)
+		}
+
+		return resp
+	}
+
+	expectError := func(resp *logical.Response, expect string) {
+		t.Helper()
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), expect) {
+			t.Fatalf("expected error containing %q, got %+v", expect, resp)
+		}
+	}
+
+	expectError(request(logical.UpdateOperation, "alias/"+alias, map[string]interface{}{"cluster": "missing"}), "is not registered")
+	expectError(request(logical.UpdateOperation, "alias/clone", map[string]interface{}{"cluster": testCluster}), "cannot shadow clusters")
+
+	resp := request(logical.UpdateOperation, "alias/"+alias, map[string]interface{}{"cluster": testCluster})
+	if resp == nil || resp.IsError() || resp.Data["cluster"] != testCluster {
+		t.Fatalf("failed to write alias. resp: %+v", resp)
+	}
+
+	// The alias can be used in place of the cluster name
+	resp = request(logical.ReadOperation, "cluster/"+alias, nil)
+	if resp == nil || resp.IsError() || resp.Data["host"] != testCluster {
+		t.Fatalf("failed to read cluster using alias. resp: %+v", resp)
+	}
+
+	resp = request(logical.ReadOperation, "cluster/"+alias+"/"+testDb, nil)
+	if resp == nil || resp.IsError() {
+		t.Fatalf("failed to read database using alias. resp: %+v", resp)
+	}
+
+	expectError(request(logical.UpdateOperation, "cluster/"+alias, map[string]interface{}{}), "is an alias of cluster "+testCluster)
+
+	// Client certificate roles fail after the cluster is resolved when no CA is configured
+	resp = request(logical.UpdateOperation, "roles/"+testRole, map[string]interface{}{"credential_type": credentialTypeClientCert})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to write role. resp: %+v", resp)
+	}
+
+	expectError(request(logical.ReadOperation, "creds/"+alias+"/"+testDb+"/"+testRole, nil), "no client CA is configured for cluster "+testCluster)
+
+	expectError(request(logical.UpdateOperation, "alias/"+alias+"/cutover", map[string]interface{}{"target": "clone", "source": "other"}), "points to cluster "+testCluster)
+	expectError(request(logical.UpdateOperation, "alias/"+alias+"/cutover", map[string]interface{}{"target": testCluster}), "already points")
+	expectError(request(logical.UpdateOperation, "alias/missing/cutover", map[string]interface{}{"target": "clone"}), "is not configured")
+
+	resp = request(logical.UpdateOperation, "alias/"+alias+"/cutover", map[string]interface{}{
+		"target":        "clone",
+		"source":        testCluster,
+		"delete_source": true,
+	})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("failed to cutover alias. resp: %+v", resp)
+	}
+
+	if resp.Data["cluster"] != "clone" || resp.Data["previous_cluster"] != testCluster {
+		t.Fatalf("unexpected alias after cutover %+v", resp.Data)
+	}
+
+	source, err := loadClusterEntry(ctx, storage, testCluster)
+	if err != nil {
+		t.Fatalf("failed to load source cluster. %s", err)
+	}
+
+	if !source.IsDisabled() {
+		t.Fatalf("expected source cluster to be deleted after cutover")
+	}
+
+	db, err := loadDbEntry(ctx, storage, testCluster, testDb)
+	if err != nil || !db.IsDisabled() {
+		t.Fatalf("expected database of source cluster to be deleted after cutover. err: %v", err)
+	}
+
+	expectError(request(logical.ReadOperation, "creds/"+alias+"/"+testDb+"/"+testRole, nil), "no client CA is configured for cluster clone")
+
+	// Deleted clusters cannot be the target of an alias
+	expectError(request(logical.UpdateOperation, "alias/"+alias+"/cutover", map[string]interface{}{"target": testCluster}), "is deleted")
+
+	resp = request(logical.ListOperation, "alias/", nil)
+	if resp == nil || len(resp.Data["keys"].([]string)) != 1 {
+		t.Fatalf("expected one alias, got %+v", resp)
+	}
+
+	request(logical.DeleteOperation, "alias/"+alias, nil)
+	if resp = request(logical.ReadOperation, "alias/"+alias, nil); resp != nil {
+		t.Fatalf("expected alias to be deleted, got %+v", resp)
+	}
+}
+
+func TestClusterAlias_removeTarget(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	const alias = "orders-primary"
+	if err := storeClusterEntry(ctx, storage, testCluster, &ClusterConfig{Host: testCluster, Port: 5432, Database: "postgres"}); err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	if err := storeClusterAlias(ctx, storage, alias, &ClusterAlias{Cluster: testCluster}); err != nil {
+		t.Fatalf("failed to store alias. %s", err)
+	}
+
+	request := func(op logical.Operation, path string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatalf("%s %s: unexpected error. %s", op, path, err)
+		}
+
+		return resp
+	}
+
+	expect := "Cluster " + testCluster + " is the target of aliases " + alias
+	resp := request(logical.DeleteOperation, "cluster/"+testCluster)
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), expect) {
+		t.Fatalf("expected delete to be refused, got %+v", resp)
+	}
+
+	// Delete and purge require the name of the cluster
+	notRegistered := "Cluster with name " + alias + " is not registered"
+	for _, p := range []string{"cluster/" + alias, "gc/cluster/" + alias} {
+		resp := request(logical.DeleteOperation, p)
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), notRegistered) {
+			t.Fatalf("expected %s to be rejected, got %+v", p, resp)
+		}
+	}
+
+	c, err := loadClusterEntry(ctx, storage, testCluster)
+	if err != nil || c.IsDisabled() {
+		t.Fatalf("expected cluster to be kept. err: %v", err)
+	}
+
+	// Clusters deleted before the alias was written cannot be purged either
+	if err := b.(*backend).disableCluster(ctx, storage, testCluster, c); err != nil {
+		t.Fatalf("failed to disable cluster. %s", err)
+	}
+
+	resp = request(logical.DeleteOperation, "gc/cluster/"+testCluster)
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), expect) {
+		t.Fatalf("expected purge to be refused, got %+v", resp)
+	}
+
+	if _, err := loadClusterEntry(ctx, storage, testCluster); err != nil {
+		t.Fatalf("expected cluster to be kept. err: %v", err)
+	}
+
+	request(logical.DeleteOperation, "alias/"+alias)
+	if resp = request(logical.DeleteOperation, "gc/cluster/"+testCluster); resp != nil && resp.IsError() {
+		t.Fatalf("failed to purge cluster. resp: %+v", resp)
+	}
+
+	if _, err := loadClusterEntry(ctx, storage, testCluster); err != ErrNotFound {
+		t.Fatalf("expected cluster to be purged, got %v", err)
+	}
+}
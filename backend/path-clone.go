@@ -2,9 +2,14 @@ package backend
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/dbtxn"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lib/pq"
+	"strings"
 )
 
 func (b *backend) pathCloneUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -19,8 +24,21 @@ func (b *backend) pathCloneUpdate(ctx context.Context, req *logical.Request, dat
 	}
 
 	inheritDeleted := data.Get("inherit_deleted_db").(bool)
+	include := data.Get("include").([]string)
+	exclude := data.Get("exclude").([]string)
+	dryRun := data.Get("dry_run").(bool)
+
+	overrideUsername := data.Get("root_username").(string)
+	overridePassword := data.Get("root_password").(string)
+	if overrideUsername != "" && overridePassword == "" {
+		return logical.ErrorResponse("root_password is required when root_username is set"), nil
+	}
+
+	clusterName, err := resolveClusterName(ctx, req.Storage, data.Get("cluster").(string))
+	if err != nil {
+		return nil, err
+	}
 
-	clusterName := data.Get("cluster").(string)
 	cluster, err := loadClusterEntry(ctx, req.Storage, clusterName)
 	if err == ErrNotFound {
 		return logical.ErrorResponse(fmt.Sprintf("Source cluster %s is not configured. Use cluster/%s to configure it first", clusterName, clusterName)), nil
@@ -39,6 +57,11 @@ func (b *backend) pathCloneUpdate(ctx context.Context, req *logical.Request, dat
 		return logical.ErrorResponse("target cluster name cannot be empty"), nil
 	}
 
+	resp, err := checkNotAlias(ctx, req.Storage, targetName)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
 	existing, err := loadClusterEntry(ctx, req.Storage, targetName)
 	if err != nil && err != ErrNotFound {
 		return nil, err
@@ -50,27 +73,101 @@ func (b *backend) pathCloneUpdate(ctx context.Context, req *logical.Request, dat
 
 	cluster.Host = targetHost
 	cluster.Port = targetPort
-	resp := &logical.Response{}
+	resp = &logical.Response{}
 
+	// A dry run reports the failure to connect as the management user
+	// instead of failing, since the role may be missing on the clone. When
+	// override root credentials are provided the management role is
+	// reconciled using the root connection instead.
+	mgmtConnection := "ok"
 	db, err := b.makeConn(cluster.dsn(connTypeMgmt))
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect with clone as existing management user. error: %s", err)
-	}
-	err = db.Close()
-	if err != nil {
+		if !dryRun && overridePassword == "" {
+			return nil, fmt.Errorf("failed to connect with clone as existing management user. error: %s", err)
+		}
+
+		mgmtConnection = err.Error()
+	} else if err = db.Close(); err != nil {
 		resp.AddWarning(fmt.Sprintf("failed to close old management user connection. %s", err))
 	}
 
+	rootCredentials := "inherited"
 	db, err = b.makeConn(cluster.dsn(connTypeRoot))
-	if err != nil {
+	if err != nil && overridePassword == "" {
 		return nil, fmt.Errorf("failed to connect with clone as existing root user. error: %s", err)
 	}
 
-	newMgmtPass, err := updatePassword(ctx, db, cluster.ManagementRole)
 	if err != nil {
-		return nil, fmt.Errorf("failed to rotate the password for management user. %s", err)
+		if overrideUsername != "" {
+			cluster.Username = overrideUsername
+		}
+		cluster.Password = overridePassword
+
+		db, err = b.makeConn(cluster.dsn(connTypeRoot))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect with clone as existing root user and as override root user. error: %s", err)
+		}
+
+		rootCredentials = "override"
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	plan, err := planClone(ctx, req.Storage, db, clusterName, cluster, include, exclude, inheritDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect the clone. %s", err)
+	}
+
+	if dryRun {
+		resp.Data = plan.AsMap()
+		resp.Data["management_connection"] = mgmtConnection
+		resp.Data["root_credentials"] = rootCredentials
+		return resp, nil
+	}
+
+	if rootCredentials == "override" {
+		resp.AddWarning("The clone rejected the inherited root credentials, the override root credentials were used and their password has been rotated")
+	}
+
+	if plan.ManagementRoleExists {
+		newMgmtPass, err := updatePassword(ctx, db, cluster.ManagementRole)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rotate the password for management user. %s", err)
+		}
+		cluster.ManagementPassword = newMgmtPass
+	} else {
+		mgmtRole, mgmtPass, err := createManagementRole(ctx, db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a management role on the clone. %s", err)
+		}
+
+		// The management role must be a member of the objects owners and
+		// able to grant connect on the databases, as it is when the
+		// databases are registered
+		for _, d := range plan.Databases {
+			if !d.Inherit() {
+				continue
+			}
+
+			m := map[string]string{
+				"user":          pq.QuoteIdentifier(mgmtRole),
+				"objects_owner": pq.QuoteIdentifier(d.Config.ObjectsOwner),
+			}
+
+			if err := dbtxn.ExecuteDBQuery(ctx, db, m, queryGrantObjectsOwner); err != nil {
+				return nil, fmt.Errorf("failed to grant objects owner %s of database %s to the new management role. %s", d.Config.ObjectsOwner, d.Name, err)
+			}
+
+			if err := execConnectQueries(ctx, db, d.Config.Database, mgmtRole, queryGrantConnectManagement); err != nil {
+				return nil, fmt.Errorf("failed to grant connect on database %s to the new management role. %s", d.Name, err)
+			}
+		}
+
+		resp.AddWarning(fmt.Sprintf("Management role %s does not exist on the clone. A management role with name '%s' has been created by Vault", cluster.ManagementRole, mgmtRole))
+		cluster.ManagementRole = mgmtRole
+		cluster.ManagementPassword = mgmtPass
 	}
-	cluster.ManagementPassword = newMgmtPass
 
 	newRootPass, err := updatePassword(ctx, db, cluster.Username)
 	if err != nil {
@@ -78,12 +175,20 @@ func (b *backend) pathCloneUpdate(ctx context.Context, req *logical.Request, dat
 	}
 	cluster.Password = newRootPass
 
+	// The configuration is stored even if the management user cannot connect,
+	// the rotated passwords would be lost otherwise
+	if mgmt, err := b.makeConn(cluster.dsn(connTypeMgmt)); err != nil {
+		resp.AddWarning(fmt.Sprintf("failed to connect with clone as management user after rotating its password. %s", err))
+	} else {
+		_ = mgmt.Close()
+	}
+
 	err = storeClusterEntry(ctx, req.Storage, targetName, cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to store the configuration for clone cluster. %s", err)
 	}
 
-	total, success, errs := cloneDbConfig(ctx, req.Storage, clusterName, targetName, inheritDeleted)
+	total, success, errs := cloneDbConfig(ctx, req.Storage, targetName, plan.Databases)
 	if errs != nil && len(errs) > 0 {
 		for _, e := range errs {
 			resp.AddWarning(e.Error())
@@ -91,33 +196,175 @@ func (b *backend) pathCloneUpdate(ctx context.Context, req *logical.Request, dat
 	}
 
 	resp.AddWarning(fmt.Sprintf("%d of %d databases inherited successfully", success, total))
+	if len(plan.Excluded) > 0 {
+		resp.AddWarning(fmt.Sprintf("%d databases excluded by the include and exclude filters", len(plan.Excluded)))
+	}
+
+	if data.Get("copy_metadata").(bool) {
+		overrides := data.Get("metadata").(map[string]string)
+		copied, rejected, err := b.cloneMetadata(ctx, req.Storage, clusterName, targetName, overrides)
+		if err != nil {
+			resp.AddWarning(fmt.Sprintf("failed to copy metadata to clone cluster. %s", err))
+		}
+
+		for id, violations := range rejected {
+			resp.AddWarning(fmt.Sprintf("metadata of %s is not copied, it violates the metadata schema: %s", id, strings.Join(violations, "; ")))
+		}
+
+		resp.AddWarning(fmt.Sprintf("metadata of %d objects copied to clone cluster", copied))
+	}
+
 	return resp, nil
 }
 
-func cloneDbConfig(ctx context.Context, storage logical.Storage, source, target string, inheritDeleted bool) (total, success int, errs []error) {
-	dbs, err := storage.List(ctx, PathDatabase.For(source, ""))
-	if err != nil {
-		return 0, 0, []error{
-			fmt.Errorf("failed to list databases in existing cluster. %s", err),
+// clonePlan describes the databases of the source cluster that a clone
+// inherits, and whether they exist on the clone.
+type clonePlan struct {
+	Databases            []*cloneDatabase
+	Excluded             []string
+	ManagementRoleExists bool
+}
+
+type cloneDatabase struct {
+	Name              string
+	Config            *DbConfig
+	Exists            bool
+	ObjectsOwnerExist bool
+	Err               error
+}
+
+// Inherit returns true if the database is inherited by the clone
+func (d *cloneDatabase) Inherit() bool {
+	return d.Err == nil && d.Exists && d.ObjectsOwnerExist
+}
+
+func (p *clonePlan) AsMap() map[string]interface{} {
+	databases := make(map[string]interface{}, len(p.Databases))
+	for _, d := range p.Databases {
+		info := map[string]interface{}{
+			"exists":               d.Exists,
+			"objects_owner_exists": d.ObjectsOwnerExist,
+			"inherit":              d.Inherit(),
+		}
+
+		if d.Config != nil {
+			info["objects_owner"] = d.Config.ObjectsOwner
+			info["deleted"] = d.Config.IsDisabled()
 		}
+
+		if d.Err != nil {
+			info["error"] = d.Err.Error()
+		}
+
+		databases[d.Name] = info
+	}
+
+	return map[string]interface{}{
+		"databases":              databases,
+		"excluded":               p.Excluded,
+		"management_role_exists": p.ManagementRoleExists,
+	}
+}
+
+// filterCloneDatabases returns the databases matched by the include patterns,
+// or all databases if there are none, and not matched by the exclude patterns.
+// Patterns support a leading or trailing '*'.
+func filterCloneDatabases(names, include, exclude []string) (selected, excluded []string) {
+	matchesAny := func(patterns []string, name string) bool {
+		for _, p := range patterns {
+			if strutil.GlobbedStringsMatch(p, name) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, name := range names {
+		if (len(include) > 0 && !matchesAny(include, name)) || matchesAny(exclude, name) {
+			excluded = append(excluded, name)
+			continue
+		}
+
+		selected = append(selected, name)
+	}
+
+	return
+}
+
+// planClone selects the databases of the source cluster that are inherited
+// by the clone, and verifies that they and their objects owner exist on the
+// clone using the connection db.
+func planClone(ctx context.Context, storage logical.Storage, db *sql.DB, source string, cluster *ClusterConfig,
+	include, exclude []string, inheritDeleted bool) (*clonePlan, error) {
+	names, err := storage.List(ctx, PathDatabase.For(source, ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases in existing cluster. %s", err)
+	}
+
+	plan := &clonePlan{}
+	err = db.QueryRowContext(ctx, queryRoleExists, cluster.ManagementRole).Scan(&plan.ManagementRoleExists)
+	if err != nil {
+		return nil, err
 	}
 
-	total = len(dbs)
-	for _, dbname := range dbs {
-		db, err := loadDbEntry(ctx, storage, source, dbname)
+	var selected []string
+	selected, plan.Excluded = filterCloneDatabases(names, include, exclude)
+	for _, name := range selected {
+		d := &cloneDatabase{Name: name}
+		d.Config, d.Err = loadDbEntry(ctx, storage, source, name)
+		if d.Err != nil {
+			d.Err = fmt.Errorf("failed to load configuration for existing database %s. %s", name, d.Err)
+			plan.Databases = append(plan.Databases, d)
+			continue
+		}
+
+		if d.Config.IsDisabled() && !inheritDeleted {
+			continue
+		}
+
+		err = db.QueryRowContext(ctx, queryDbExists, d.Config.Database).Scan(&d.Exists)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("failed to load configuration for existing database %s. %s", dbname, err))
+			return nil, err
+		}
+
+		err = db.QueryRowContext(ctx, queryRoleExists, d.Config.ObjectsOwner).Scan(&d.ObjectsOwnerExist)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Databases = append(plan.Databases, d)
+	}
+
+	return plan, nil
+}
+
+// cloneDbConfig stores the configuration of the planned databases in the
+// target cluster. Databases that do not exist on the clone, or whose objects
+// owner does not exist, are not inherited.
+func cloneDbConfig(ctx context.Context, storage logical.Storage, target string, databases []*cloneDatabase) (total, success int, errs []error) {
+	total = len(databases)
+	for _, d := range databases {
+		if d.Err != nil {
+			errs = append(errs, d.Err)
+			continue
+		}
+
+		if !d.Exists {
+			errs = append(errs, fmt.Errorf("database %s does not exist on the clone and is not inherited", d.Name))
 			continue
 		}
 
-		if db.IsDisabled() && !inheritDeleted {
+		if !d.ObjectsOwnerExist {
+			errs = append(errs, fmt.Errorf("objects owner %s of database %s does not exist on the clone, the database is not inherited", d.Config.ObjectsOwner, d.Name))
 			continue
 		}
 
+		db := *d.Config
 		db.Cluster = target
-		err = storeDbEntry(ctx, storage, target, dbname, db)
+		err := storeDbEntry(ctx, storage, target, d.Name, &db)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("failed to store configuration for cloned database %s. %s", dbname, err))
+			errs = append(errs, fmt.Errorf("failed to store configuration for cloned database %s. %s", d.Name, err))
 			continue
 		}
 
@@ -1,8 +1,14 @@
 package backend
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	logicaltest "github.com/hashicorp/vault/helper/testhelpers/logical"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lib/pq"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -22,6 +28,7 @@ func TestCloneUpdate(t *testing.T) {
 		Steps: []logicaltest.TestStep{
 			testAccWriteClusterConfig(t, "cluster/"+testCloneSourceCluster, attrs, false),
 			testAccWriteDbConfig(t, "cluster/"+testCloneSourceCluster+"/"+testCloneDb),
+			testAccCloneDryRun(t, attrs, testCloneSourceCluster, testCloneTargetCluster, testCloneDb),
 			testAccCloneCluster(t, attrs, testCloneSourceCluster, testCloneTargetCluster),
 		},
 	})
@@ -46,3 +53,196 @@ func testAccCloneCluster(t *testing.T, attrs map[string]interface{}, sourceName,
 		},
 	}
 }
+
+func testAccCloneDryRun(t *testing.T, attrs map[string]interface{}, sourceName, targetName, dbName string) logicaltest.TestStep {
+	return logicaltest.TestStep{
+		Operation: logical.UpdateOperation,
+		Path:      "clone/" + sourceName,
+		ErrorOk:   false,
+		Data: map[string]interface{}{
+			"target":  targetName,
+			"host":    attrs["host"].(string),
+			"port":    attrs["port"].(int),
+			"dry_run": true,
+		},
+		Check: func(resp *logical.Response) error {
+			if resp.Data["management_connection"] != "ok" || resp.Data["management_role_exists"] != true {
+				return fmt.Errorf("expected management role to exist on clone, got %+v", resp.Data)
+			}
+
+			db, ok := resp.Data["databases"].(map[string]interface{})[dbName].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("expected database %s to be reported, got %+v", dbName, resp.Data)
+			}
+
+			if db["exists"] != true || db["objects_owner_exists"] != true || db["inherit"] != true {
+				return fmt.Errorf("expected database %s to be inherited, got %+v", dbName, db)
+			}
+
+			return nil
+		},
+	}
+}
+
+func TestFilterCloneDatabases(t *testing.T) {
+	names := []string{"orders", "orders-archive", "billing", "search"}
+	cases := []struct {
+		include, exclude   []string
+		selected, excluded []string
+	}{
+		{
+			selected: names,
+		},
+		{
+			include:  []string{"orders*", "search"},
+			selected: []string{"orders", "orders-archive", "search"},
+			excluded: []string{"billing"},
+		},
+		{
+			exclude:  []string{"*-archive"},
+			selected: []string{"orders", "billing", "search"},
+			excluded: []string{"orders-archive"},
+		},
+		{
+			include:  []string{"orders*"},
+			exclude:  []string{"*-archive"},
+			selected: []string{"orders"},
+			excluded: []string{"orders-archive", "billing", "search"},
+		},
+	}
+
+	for _, tc := range cases {
+		selected, excluded := filterCloneDatabases(names, tc.include, tc.exclude)
+		if !reflect.DeepEqual(tc.selected, selected) || !reflect.DeepEqual(tc.excluded, excluded) {
+			t.Errorf("include %v, exclude %v: expected %v and %v, got %v and %v", tc.include, tc.exclude, tc.selected, tc.excluded, selected, excluded)
+		}
+	}
+}
+
+func TestCloneUpdate_rootOverrideValidation(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "clone/" + testCluster,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"target":        "clone",
+			"host":          "localhost",
+			"root_username": "postgres",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error. %s", err)
+	}
+
+	if resp == nil || !resp.IsError() || resp.Error().Error() != "root_password is required when root_username is set" {
+		t.Fatalf("expected root_password to be required, got %+v", resp)
+	}
+}
+
+func TestCloneUpdate_rootOverride(t *testing.T) {
+	b := testGetBackend(t)
+	cleanup, attrs := prepareTestContainer(t)
+	defer cleanup()
+
+	const (
+		source = "test-clone-source"
+		target = "test-clone-target"
+		dbName = "test-clone-db"
+	)
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("request to %s failed. err: %v, resp: %v", path, err, resp)
+		}
+
+		return resp
+	}
+
+	request("cluster/"+source, attrs)
+	request("cluster/"+source+"/"+dbName, nil)
+
+	cluster, err := loadClusterEntry(ctx, storage, source)
+	if err != nil {
+		t.Fatalf("failed to load cluster. %s", err)
+	}
+
+	database, err := loadDbEntry(ctx, storage, source, dbName)
+	if err != nil {
+		t.Fatalf("failed to load database. %s", err)
+	}
+
+	// The snapshot has a different root password and no management role
+	root, err := sql.Open("postgres", cluster.dsn(connTypeRoot))
+	if err != nil {
+		t.Fatalf("failed to open database connection. %s", err)
+	}
+	defer root.Close()
+
+	for _, query := range []string{
+		fmt.Sprintf("drop role %s", pq.QuoteIdentifier(cluster.ManagementRole)),
+		fmt.Sprintf("alter role %s with password 'override-secret'", pq.QuoteIdentifier(cluster.Username)),
+	} {
+		if _, err := root.Exec(query); err != nil {
+			t.Fatalf("failed to prepare clone. %s", err)
+		}
+	}
+
+	resp := request("clone/"+source, map[string]interface{}{
+		"target":        target,
+		"host":          attrs["host"].(string),
+		"port":          attrs["port"].(int),
+		"root_password": "override-secret",
+	})
+
+	overridden := false
+	for _, w := range resp.Warnings {
+		if strings.Contains(w, "failed to connect") {
+			t.Fatalf("unexpected warning on clone: %s", w)
+		}
+
+		overridden = overridden || strings.Contains(w, "override root credentials were used")
+	}
+
+	if !overridden {
+		t.Fatalf("expected the override root credentials to be used, got warnings %v", resp.Warnings)
+	}
+
+	clone, err := loadClusterEntry(ctx, storage, target)
+	if err != nil {
+		t.Fatalf("failed to load clone cluster. %s", err)
+	}
+
+	if clone.ManagementRole == cluster.ManagementRole {
+		t.Fatalf("expected a new management role to be created")
+	}
+
+	if _, err := loadDbEntry(ctx, storage, target, dbName); err != nil {
+		t.Fatalf("expected database to be inherited. %s", err)
+	}
+
+	mgmt, err := sql.Open("postgres", clone.dsn(connTypeMgmt))
+	if err != nil {
+		t.Fatalf("failed to open management connection. %s", err)
+	}
+	defer mgmt.Close()
+
+	var member bool
+	if err := mgmt.QueryRow(queryUserHasRole, clone.ManagementRole, database.ObjectsOwner).Scan(&member); err != nil {
+		t.Fatalf("failed to check membership as new management role. %s", err)
+	}
+
+	if !member {
+		t.Fatalf("expected new management role to be a member of objects owner %s", database.ObjectsOwner)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/lib/pq"
 	"strings"
+	"time"
 )
 
 type ClusterConfig struct {
@@ -25,6 +26,9 @@ type ClusterConfig struct {
 	Database              string `json:"database" mapstructure:"database"`
 	Disabled              *bool  `json:"disabled" mapstructure:"disabled"`
 	SSLMode               string `json:"ssl_mode" mapstructure:"ssl_mode"`
+	MaxCredentialTTL      int    `json:"max_credential_ttl" mapstructure:"max_credential_ttl"`
+	ClientCACertificate   string `json:"client_ca_certificate" mapstructure:"client_ca_certificate"`
+	ClientCAPrivateKey    string `json:"client_ca_private_key" mapstructure:"client_ca_private_key"`
 }
 
 func (c *ClusterConfig) AsMap() map[string]interface{} {
@@ -41,9 +45,15 @@ func (c *ClusterConfig) AsMap() map[string]interface{} {
 		"ssl_mode":                c.SSLMode,
 		"management_role":         c.ManagementRole,
 		"management_password":     c.ManagementPassword,
+		"max_credential_ttl":      c.MaxCredentialTTL,
+		"client_ca_certificate":   c.ClientCACertificate,
 	}
 }
 
+func (c *ClusterConfig) GetMaxCredentialTTL() time.Duration {
+	return time.Duration(c.MaxCredentialTTL) * time.Second
+}
+
 func (c *ClusterConfig) IsDisabled() bool {
 	if c.Disabled == nil {
 		return false
@@ -74,13 +84,17 @@ func (c *ClusterConfig) validate() error {
 		return fmt.Errorf("Maintenance database must be set")
 	}
 
+	if c.MaxCredentialTTL < 0 {
+		return fmt.Errorf("Invalid max_credential_ttl %d", c.MaxCredentialTTL)
+	}
+
 	switch c.SSLMode {
 	case "disable", "require", "verify-ca", "verify-full":
 	default:
 		return fmt.Errorf("Invalid ssl_mode %s, valid options are 'disable', 'require', 'verify-ca', or 'verify-full'", c.SSLMode)
 	}
 
-	return nil
+	return validateClientCA(c.ClientCACertificate, c.ClientCAPrivateKey)
 }
 
 func (c *ClusterConfig) dsn(t connType) string {
@@ -93,6 +107,10 @@ func (c *ClusterConfig) dsnForDb(t connType, db string) string {
 		u, p = c.ManagementRole, c.ManagementPassword
 	}
 
+	return c.dsnForUser(u, p, db)
+}
+
+func (c *ClusterConfig) dsnForUser(u, p, db string) string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?timezone=utc&sslmode=%s",
 		u, p, c.Host, c.Port, db, c.SSLMode)
 }
@@ -118,6 +136,12 @@ func (c *ClusterConfig) loadFromFields(data *framework.FieldData) error {
 			c.Database = data.Get("database").(string)
 		case "ssl_mode":
 			c.SSLMode = data.Get("ssl_mode").(string)
+		case "max_credential_ttl":
+			c.MaxCredentialTTL = data.Get("max_credential_ttl").(int)
+		case "client_ca_certificate":
+			c.ClientCACertificate = data.Get("client_ca_certificate").(string)
+		case "client_ca_private_key":
+			c.ClientCAPrivateKey = data.Get("client_ca_private_key").(string)
 		}
 	}
 
@@ -163,7 +187,7 @@ func updatePassword(ctx context.Context, db *sql.DB, username string) (string, e
 		"password": newPass,
 	}
 
-	err = dbtxn.ExecuteDBQuery(ctx, db, cpQ, queryUpdatePassword)
+	err = execWithPassword(ctx, db, cpQ, queryUpdatePassword)
 	if err != nil {
 		return "", err
 	}
@@ -192,7 +216,7 @@ func createManagementRole(ctx context.Context, db *sql.DB) (string, string, erro
 		"password": rolePass,
 	}
 
-	err = dbtxn.ExecuteDBQuery(ctx, db, crQ, queryCreateManagementRole)
+	err = execWithPassword(ctx, db, crQ, queryCreateManagementRole)
 	if err != nil {
 		return "", "", err
 	}
@@ -200,8 +224,34 @@ func createManagementRole(ctx context.Context, db *sql.DB) (string, string, erro
 	return roleName, rolePass, nil
 }
 
+// execWithPassword executes a query that sets the password of a user
+// in its own transaction so the password can be protected
+func execWithPassword(ctx context.Context, db *sql.DB, m map[string]string, query string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := protectPassword(ctx, tx, m); err != nil {
+		return err
+	}
+
+	if err := dbtxn.ExecuteTxQuery(ctx, tx, m, query); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (b *backend) pathClusterRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	clusterName := data.Get("cluster").(string)
+	clusterName, err := resolveClusterName(ctx, req.Storage, data.Get("cluster").(string))
+	if err != nil {
+		return nil, err
+	}
+
 	c, err := loadClusterEntry(ctx, req.Storage, clusterName)
 	if err == ErrNotFound {
 		return logical.ErrorResponse(fmt.Sprintf("Cluster with name %s is not registered", clusterName)), nil
@@ -222,6 +272,11 @@ func (b *backend) pathClusterRead(ctx context.Context, req *logical.Request, dat
 
 func (b *backend) pathClusterUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	clusterName := data.Get("cluster").(string)
+	resp, err := checkNotAlias(ctx, req.Storage, clusterName)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
 	existing, err := loadClusterEntry(ctx, req.Storage, clusterName)
 	if err != nil && err != ErrNotFound {
 		return nil, err
@@ -265,7 +320,7 @@ func (b *backend) pathClusterUpdate(ctx context.Context, req *logical.Request, d
 		return nil, err
 	}
 
-	resp := &logical.Response{}
+	resp = &logical.Response{}
 	resp.AddWarning("The password has been changed by Vault. Old password will no longer work")
 	resp.AddWarning(fmt.Sprintf("A management role with name '%s' has been created by Vault", mgmtRole))
 
@@ -273,8 +328,10 @@ func (b *backend) pathClusterUpdate(ctx context.Context, req *logical.Request, d
 }
 
 func (b *backend) pathClusterDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	clusterName := data.Get("cluster").(string)
+	b.aliasLock.Lock()
+	defer b.aliasLock.Unlock()
 
+	clusterName := data.Get("cluster").(string)
 	c, err := loadClusterEntry(ctx, req.Storage, clusterName)
 	if err == ErrNotFound {
 		return logical.ErrorResponse(fmt.Sprintf("Cluster with name %s is not registered", clusterName)), nil
@@ -288,16 +345,37 @@ func (b *backend) pathClusterDelete(ctx context.Context, req *logical.Request, d
 		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is deleted. Use gc/cluster to manage deleted clusters", clusterName)), nil
 	}
 
-	// Mark all databases within cluster as disabled
-	databases, err := req.Storage.List(ctx, PathDatabase.For(clusterName, ""))
+	resp, err := checkNotAliasTarget(ctx, req.Storage, clusterName)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	err = b.disableCluster(ctx, req.Storage, clusterName, c)
 	if err != nil {
 		return nil, err
 	}
 
+	warnings := []string{
+		"Use gc/cluster to manage deleted clusters",
+	}
+
+	return &logical.Response{
+		Warnings: warnings,
+	}, nil
+}
+
+// disableCluster marks the cluster, all databases within it and their
+// metadata as deleted
+func (b *backend) disableCluster(ctx context.Context, storage logical.Storage, clusterName string, c *ClusterConfig) error {
+	databases, err := storage.List(ctx, PathDatabase.For(clusterName, ""))
+	if err != nil {
+		return err
+	}
+
 	for _, dbName := range databases {
-		db, err := loadDbEntry(ctx, req.Storage, clusterName, dbName)
+		db, err := loadDbEntry(ctx, storage, clusterName, dbName)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if db.IsDisabled() {
@@ -306,26 +384,20 @@ func (b *backend) pathClusterDelete(ctx context.Context, req *logical.Request, d
 
 		db.Disable()
 
-		err = storeDbEntry(ctx, req.Storage, clusterName, dbName, db)
+		err = storeDbEntry(ctx, storage, clusterName, dbName, db)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	c.Disable()
 
-	err = storeClusterEntry(ctx, req.Storage, clusterName, c)
+	err = storeClusterEntry(ctx, storage, clusterName, c)
 	if err != nil {
-		return nil, err
-	}
-
-	warnings := []string{
-		"Use gc/cluster to manage deleted clusters",
+		return err
 	}
 
-	return &logical.Response{
-		Warnings: warnings,
-	}, nil
+	return b.markMetadataDeleted(ctx, storage, clusterName, "")
 }
 
 func (b *backend) pathClustersList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+type MountConfig struct {
+	RootExecutionRoles  []string `json:"root_execution_roles" mapstructure:"root_execution_roles"`
+	ClientCACertificate string   `json:"client_ca_certificate" mapstructure:"client_ca_certificate"`
+	ClientCAPrivateKey  string   `json:"client_ca_private_key" mapstructure:"client_ca_private_key"`
+}
+
+func (c *MountConfig) AsMap() map[string]interface{} {
+	return map[string]interface{}{
+		"root_execution_roles":  c.RootExecutionRoles,
+		"client_ca_certificate": c.ClientCACertificate,
+	}
+}
+
+func (c *MountConfig) loadFromFields(data *framework.FieldData) error {
+	for k := range data.Schema {
+		v, ok := data.GetOk(k)
+		if !ok {
+			continue
+		}
+
+		switch k {
+		case "root_execution_roles":
+			c.RootExecutionRoles = strutil.RemoveDuplicates(v.([]string), false)
+		case "client_ca_certificate":
+			c.ClientCACertificate = v.(string)
+		case "client_ca_private_key":
+			c.ClientCAPrivateKey = v.(string)
+		}
+	}
+
+	return validateClientCA(c.ClientCACertificate, c.ClientCAPrivateKey)
+}
+
+// CanExecuteAsRoot returns true if the role is allowed to use root connection
+func (c *MountConfig) CanExecuteAsRoot(roleName string) bool {
+	return strutil.StrListContains(c.RootExecutionRoles, roleName)
+}
+
+// loadMountConfig returns an empty configuration if the mount
+// has not been configured yet.
+func loadMountConfig(ctx context.Context, storage logical.Storage) (*MountConfig, error) {
+	entry, err := storage.Get(ctx, PathConfig.For())
+	if err != nil {
+		return nil, err
+	}
+
+	c := &MountConfig{}
+	if entry == nil {
+		return c, nil
+	}
+
+	err = entry.DecodeJSON(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func storeMountConfig(ctx context.Context, storage logical.Storage, cfg *MountConfig) error {
+	entry, err := logical.StorageEntryJSON(PathConfig.For(), cfg)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := loadMountConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: c.AsMap(),
+	}, nil
+}
+
+func (b *backend) pathConfigUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := loadMountConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.loadFromFields(data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	err = storeMountConfig(ctx, req.Storage, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{}, nil
+}
@@ -0,0 +1,598 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/dbtxn"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lib/pq"
+)
+
+// Roles that span multiple databases create the user in the maintenance
+// database of cluster and then grant access in every database.
+var defaultClusterCreationSQL = []string{
+	"create role {{user}} with login password '{{password}}' valid until '{{expiration}}' role {{group}}",
+}
+
+var defaultClusterRevocationSQL = []string{
+	"drop role if exists {{user}}",
+}
+
+var defaultDatabaseCreationSQL = []string{
+	"grant connect on database {{database}} to {{user}}",
+	"grant {{objects_owner}} to {{user}}",
+}
+
+var defaultDatabaseRevocationSQL = []string{
+	"set role {{user}}",
+	"reassign owned by {{user}} to {{objects_owner}}",
+	"drop owned by {{user}}",
+	"reset role",
+	"revoke {{objects_owner}} from {{user}}",
+	"revoke connect on database {{database}} from {{user}}",
+}
+
+// Template variables available to the statements of roles that span multiple databases
+var (
+	clusterCreationTemplateVars   = []string{"user", "password", "expiration", "group"}
+	clusterRevocationTemplateVars = []string{"user", "group"}
+	clusterRenewTemplateVars      = []string{"user", "expiration", "group"}
+	databaseTemplateVars          = []string{"user", "database", "objects_owner", "group"}
+)
+
+func (b *backend) secretClusterCredsCreate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	clusterName, err := resolveClusterName(ctx, req.Storage, data.Get("cluster").(string))
+	if err != nil {
+		return nil, err
+	}
+	roleName := data.Get("role").(string)
+
+	cluster, err := loadClusterEntry(ctx, req.Storage, clusterName)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is not configured", clusterName)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster.IsDisabled() {
+		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is marked as deleted. Cannot generate new credentials", clusterName)), nil
+	}
+
+	role, err := loadRoleEntry(ctx, req.Storage, roleName)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s is not configured", roleName)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !role.IsClusterScoped() {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s is scoped to a single database, request credentials using creds/%s/<database>/%s", roleName, clusterName, roleName)), nil
+	}
+
+	resp, err := b.checkRootExecution(ctx, req, roleName, role)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	databases, unavailable, err := resolveRoleDatabases(ctx, req.Storage, clusterName, role)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unavailable) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("Databases %s are not available in cluster %s", strings.Join(unavailable, ", "), clusterName)), nil
+	}
+
+	if len(databases) == 0 {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s does not match any database in cluster %s", roleName, clusterName)), nil
+	}
+
+	groups := strutil.RemoveDuplicates(data.Get("groups").([]string), false)
+	if err := checkAllowedGroups(roleName, role, groups); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if len(groups) > 0 {
+		db, err := b.getConn(ctx, req.Storage, role.GetConnType(), clusterName, "")
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = checkGroupsExist(ctx, db, groups)
+		_ = db.Close()
+		if resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	username, password, err := generateCredentials(req.DisplayName)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := role.GetDefaultTTL()
+	if requestedTTL := time.Duration(data.Get("ttl").(int)) * time.Second; requestedTTL > 0 {
+		ttl = requestedTTL
+	}
+
+	maxTTL := b.credsMaxTTL(role, cluster, databases...)
+	ttl, warnings, err := framework.CalculateTTL(b.System(), 0, ttl, 0, maxTTL, 0, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]string{
+		"user":       pq.QuoteIdentifier(username),
+		"password":   password,
+		"expiration": formatExpiration(time.Now().Add(ttl)),
+		"group":      pq.QuoteIdentifier(cluster.ManagementRole),
+	}
+
+	// Group memberships are granted and revoked in the maintenance database
+	grantGroups, revokeGroups := groupStatements(groups)
+	revocationSQL := append(revokeGroups, role.RevocationStatement...)
+
+	connT := role.GetConnType()
+	err = b.execInDatabase(ctx, req.Storage, connT, clusterName, "", append(role.CreationStatement, grantGroups...), m, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var granted []*DbConfig
+	for _, database := range databases {
+		dm := databaseTemplateMap(username, cluster, database)
+		err = b.execInDatabase(ctx, req.Storage, connT, clusterName, database.Database, role.GetDatabaseCreationStatement(), dm, nil)
+		if err != nil {
+			// Statements in the databases cannot be executed in a single transaction,
+			// the access granted so far is revoked before the user is dropped.
+			_ = b.revokeClusterUser(ctx, req.Storage, connT, clusterName, cluster, username, granted,
+				role.DatabaseRevocationStatement, revocationSQL, func(string) {})
+			return nil, fmt.Errorf("failed to grant access in database %s: %s", database.Database, err)
+		}
+
+		granted = append(granted, database)
+	}
+
+	if role.AssumeObjectsOwner {
+		err = b.checkCanAssumeOwners(ctx, req.Storage, connT, clusterName, username, databases)
+		if err != nil {
+			_ = b.revokeClusterUser(ctx, req.Storage, connT, clusterName, cluster, username, databases,
+				role.DatabaseRevocationStatement, revocationSQL, func(string) {})
+			return logical.ErrorResponse(fmt.Sprintf("Role %s is configured to assume the objects owner. %s", roleName, err)), nil
+		}
+	}
+
+	databaseNames := make([]string, 0, len(databases))
+	for _, database := range databases {
+		databaseNames = append(databaseNames, database.Database)
+	}
+
+	leaseRef, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &LeaseEntry{
+		Username:  username,
+		Cluster:   clusterName,
+		Databases: databaseNames,
+	}
+
+	err = storeLeaseEntry(ctx, req.Storage, roleName, leaseRef, lease)
+	if err != nil {
+		_ = b.revokeClusterUser(ctx, req.Storage, connT, clusterName, cluster, username, databases,
+			role.DatabaseRevocationStatement, revocationSQL, func(string) {})
+		return nil, err
+	}
+
+	sec := map[string]interface{}{
+		"username":  username,
+		"password":  password,
+		"databases": databaseNames,
+	}
+
+	internalSec := map[string]interface{}{
+		"role":                          roleName,
+		"username":                      username,
+		"cluster":                       clusterName,
+		"databases":                     databaseNames,
+		"lease_ref":                     leaseRef,
+		"role_version":                  role.Version,
+		"execute_as":                    connT.String(),
+		"revocation_statement":          role.RevocationStatement,
+		"database_revocation_statement": role.DatabaseRevocationStatement,
+		"groups":                        groups,
+		"revocation_mode":               role.GetRevocationMode(),
+		"revocation_retention":          role.GetRevocationRetention().String(),
+	}
+
+	if len(groups) > 0 {
+		sec["groups"] = groups
+	}
+
+	resp = b.Secret(SecretCredsType).Response(sec, internalSec)
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = maxTTL
+	resp.Warnings = warnings
+
+	if connT == connTypeRoot {
+		b.Logger().Warn("issued credentials using root connection", "role", roleName, "cluster", clusterName,
+			"databases", databaseNames, "username", username, "display_name", req.DisplayName, "entity_id", req.EntityID)
+		resp.AddWarning(fmt.Sprintf("Credentials were created using the root connection of cluster %s", clusterName))
+	}
+
+	return resp, nil
+}
+
+func (b *backend) secretClusterCredsRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName, err := getInternalStr("role", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := getInternalStr("username", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterName, err := getInternalStr("cluster", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	databaseNames, err := getInternalStrSlice("databases", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := loadRoleEntry(ctx, req.Storage, roleName)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s is not configured", roleName)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := loadClusterEntry(ctx, req.Storage, clusterName)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Configuration for cluster %s is not available", clusterName)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster.IsDisabled() {
+		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is marked as deleted. Cannot renew credentials", clusterName)), nil
+	}
+
+	var databases []*DbConfig
+	for _, name := range databaseNames {
+		database, err := loadDbEntry(ctx, req.Storage, clusterName, name)
+		if err == ErrNotFound {
+			return logical.ErrorResponse(fmt.Sprintf("Configuration for database %s is not available", name)), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if database.IsDisabled() {
+			return logical.ErrorResponse(fmt.Sprintf("Database %s is marked as deleted. Cannot renew credentials", name)), nil
+		}
+
+		databases = append(databases, database)
+	}
+
+	maxTTL := b.credsMaxTTL(role, cluster, databases...)
+	ttl, warnings, err := framework.CalculateTTL(b.System(), req.Secret.Increment, role.GetDefaultTTL(), 0, maxTTL, maxTTL, req.Secret.IssueTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		m := map[string]string{
+			"user":       pq.QuoteIdentifier(username),
+			"expiration": formatExpiration(time.Now().Add(ttl).Add(5 * time.Second)),
+			"group":      pq.QuoteIdentifier(cluster.ManagementRole),
+		}
+
+		resp, err := b.checkRootExecution(ctx, req, roleName, role)
+		if resp != nil || err != nil {
+			return resp, err
+		}
+
+		db, err := b.getConn(ctx, req.Storage, role.GetConnType(), clusterName, "")
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = db.Close()
+		}()
+
+		exists, err := roleExists(ctx, db, username)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return logical.ErrorResponse(fmt.Sprintf("User %s does not exist in cluster %s. Cannot renew credentials", username, clusterName)), nil
+		}
+
+		err = b.execInDatabase(ctx, req.Storage, role.GetConnType(), clusterName, "", role.GetRenewStatement(), m, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &logical.Response{
+		Secret:   req.Secret,
+		Warnings: warnings,
+	}
+
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = maxTTL
+	return resp, nil
+}
+
+func (b *backend) secretClusterCredsRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName, err := getInternalStr("role", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := getInternalStr("username", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterName, err := getInternalStr("cluster", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	databaseNames, err := getInternalStrSlice("databases", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	revocationSQL, err := getInternalStrSlice("revocation_statement", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	dbRevocationSQL, err := getInternalStrSlice("database_revocation_statement", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := getInternalStrSlice("groups", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	_, revokeGroups := groupStatements(groups)
+	revocationSQL = append(revokeGroups, revocationSQL...)
+
+	executeAs, err := getInternalStr("execute_as", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	connT, err := parseConnType(executeAs)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{}
+
+	cluster, err := loadClusterEntry(ctx, req.Storage, clusterName)
+	if err == ErrNotFound {
+		return revokeWithoutConfig(ctx, req, roleName, fmt.Sprintf("Configuration for cluster %s cannot be found", clusterName))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var databases []*DbConfig
+	for _, name := range databaseNames {
+		database, err := loadDbEntry(ctx, req.Storage, clusterName, name)
+		if err == ErrNotFound {
+			resp.AddWarning(fmt.Sprintf("Configuration for database %s cannot be found, skipping revocation in database", name))
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		databases = append(databases, database)
+	}
+
+	if mode, retention := getRevocationMode(req.Secret.InternalData); mode != revocationModeDrop {
+		msg, err := b.revokeByDisabling(ctx, req.Storage, connT, mode, retention, &DisabledUser{
+			Username:                    username,
+			Cluster:                     clusterName,
+			Databases:                   databaseNames,
+			RevocationStatement:         revocationSQL,
+			DatabaseRevocationStatement: dbRevocationSQL,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resp.AddWarning(msg)
+	} else {
+		err = b.revokeClusterUser(ctx, req.Storage, connT, clusterName, cluster, username, databases, dbRevocationSQL, revocationSQL, resp.AddWarning)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if leaseRef, ok := req.Secret.InternalData["lease_ref"].(string); ok {
+		if err := deleteLeaseEntry(ctx, req.Storage, roleName, leaseRef); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// revokeClusterUser runs the database revocation statements in every
+// database and then drops the user using the revocation statements in the
+// maintenance database. Failed statements are reported to warn and do not
+// stop the revocation.
+func (b *backend) revokeClusterUser(ctx context.Context, storage logical.Storage, connT connType, clusterName string, cluster *ClusterConfig,
+	username string, databases []*DbConfig, dbRevocationSQL, revocationSQL []string, warn func(string)) error {
+	onErr := func(database string) func(int, string, error) {
+		return func(idx int, query string, err error) {
+			warn(fmt.Sprintf("failed to run revocation query [%d] in database %s: %q - %s", idx, database, query, err))
+		}
+	}
+
+	for _, database := range databases {
+		m := databaseTemplateMap(username, cluster, database)
+		err := b.execInDatabase(ctx, storage, connT, clusterName, database.Database, dbRevocationSQL, m, onErr(database.Database))
+		if err != nil {
+			warn(fmt.Sprintf("failed to revoke access in database %s: %s", database.Database, err))
+		}
+	}
+
+	m := map[string]string{
+		"user":  pq.QuoteIdentifier(username),
+		"group": pq.QuoteIdentifier(cluster.ManagementRole),
+	}
+
+	return b.execInDatabase(ctx, storage, connT, clusterName, "", revocationSQL, m, onErr(cluster.Database))
+}
+
+// checkCanAssumeOwners returns an error if the user cannot assume
+// the objects owner of any of the databases
+func (b *backend) checkCanAssumeOwners(ctx context.Context, storage logical.Storage, connT connType, clusterName, username string, databases []*DbConfig) error {
+	db, err := b.getConn(ctx, storage, connT, clusterName, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, database := range databases {
+		if err := checkCanAssumeRole(ctx, tx, username, database.ObjectsOwner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// execInDatabase runs the statements in a single transaction. An empty database
+// name uses the maintenance database of cluster. If onErr is nil the first failed
+// statement aborts the transaction, otherwise the failure is reported to onErr.
+func (b *backend) execInDatabase(ctx context.Context, storage logical.Storage, connT connType, clusterName, databaseName string,
+	statements []string, m map[string]string, onErr func(int, string, error)) error {
+	db, err := b.getConn(ctx, storage, connT, clusterName, databaseName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := protectPassword(ctx, tx, m); err != nil {
+		return err
+	}
+
+	for idx, query := range statements {
+		query = strings.TrimSpace(query)
+		if len(query) == 0 {
+			continue
+		}
+
+		if err := dbtxn.ExecuteTxQuery(ctx, tx, m, query); err != nil {
+			if onErr == nil {
+				return err
+			}
+
+			onErr(idx, query, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func databaseTemplateMap(username string, cluster *ClusterConfig, database *DbConfig) map[string]string {
+	return map[string]string{
+		"user":          pq.QuoteIdentifier(username),
+		"database":      pq.QuoteIdentifier(database.Database),
+		"objects_owner": pq.QuoteIdentifier(database.ObjectsOwner),
+		"group":         pq.QuoteIdentifier(cluster.ManagementRole),
+	}
+}
+
+// resolveRoleDatabases returns the databases listed on the role and the
+// databases matched by the selector of the role, sorted by name. Listed
+// databases that are not configured or disabled are returned as unavailable,
+// disabled databases that match the selector are ignored.
+func resolveRoleDatabases(ctx context.Context, storage logical.Storage, clusterName string, role *RoleConfig) ([]*DbConfig, []string, error) {
+	sel, err := parseSelector(role.DatabaseSelector)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matched, err := matchDatabases(ctx, storage, clusterName, sel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := strutil.RemoveDuplicates(append(append([]string{}, role.Databases...), matched...), false)
+
+	var databases []*DbConfig
+	var unavailable []string
+	for _, name := range names {
+		database, err := loadDbEntry(ctx, storage, clusterName, name)
+		if err != nil && err != ErrNotFound {
+			return nil, nil, err
+		}
+
+		if err == ErrNotFound || database.IsDisabled() {
+			if strutil.StrListContains(role.Databases, name) {
+				unavailable = append(unavailable, name)
+			}
+			continue
+		}
+
+		databases = append(databases, database)
+	}
+
+	return databases, unavailable, nil
+}
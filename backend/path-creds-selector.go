@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// secretCredsBySelectorCreate issues credentials in the only active database
+// whose metadata is matched by the selector, so that applications do not
+// need to know the cluster that hosts the database.
+func (b *backend) secretCredsBySelectorCreate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+
+	raw, ok := data.GetOk("selector")
+	if !ok {
+		return logical.ErrorResponse("'selector' attribute is required"), nil
+	}
+
+	sel, err := parseSelector(raw.(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid selector: %s", err)), nil
+	}
+
+	if sel.Empty() {
+		return logical.ErrorResponse("'selector' attribute is required"), nil
+	}
+
+	matches, err := resolveDatabaseSelector(ctx, req.Storage, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(matches) {
+	case 0:
+		return logical.ErrorResponse(fmt.Sprintf("Selector %q does not match any active database", sel.String())), nil
+	case 1:
+	default:
+		names := make([]string, 0, len(matches))
+		for _, m := range matches {
+			names = append(names, m.Name())
+		}
+
+		return logical.ErrorResponse(fmt.Sprintf("Selector %q matches multiple active databases: %s", sel.String(), strings.Join(names, ", "))), nil
+	}
+
+	match := matches[0]
+	resp, err := b.createDatabaseCreds(ctx, req, data, match.Cluster, match.Database, roleName)
+	if err != nil || resp == nil || resp.IsError() {
+		return resp, err
+	}
+
+	resp.Data["cluster"] = match.Cluster
+	resp.Data["database"] = match.Database
+	return resp, nil
+}
+
+// resolveDatabaseSelector returns the metadata of active databases that is
+// matched by the selector, sorted by name.
+func resolveDatabaseSelector(ctx context.Context, storage logical.Storage, sel selector) ([]*Metadata, error) {
+	objects, err := listObjectMetadata(ctx, storage, "database")
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*Metadata
+	for _, m := range objects {
+		if m.Deleted || !sel.Matches(m.Data) {
+			continue
+		}
+
+		status, err := metadataObjectStatus(ctx, storage, m)
+		if err != nil {
+			return nil, err
+		}
+
+		if status != metadataStatusActive {
+			continue
+		}
+
+		matches = append(matches, m)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Name() < matches[j].Name()
+	})
+
+	return matches, nil
+}
@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCredsBySelector_resolve(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	for _, cluster := range []string{testCluster, "clone"} {
+		if err := storeClusterEntry(ctx, storage, cluster, &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"}); err != nil {
+			t.Fatalf("failed to store cluster. %s", err)
+		}
+	}
+
+	databases := []struct {
+		cluster  string
+		database string
+		data     map[string]string
+		disabled bool
+	}{
+		{cluster: testCluster, database: "orders", data: map[string]string{"service": "orders", "env": "prod"}, disabled: true},
+		{cluster: "clone", database: "orders", data: map[string]string{"service": "orders", "env": "prod"}},
+		{cluster: testCluster, database: "billing", data: map[string]string{"service": "billing", "env": "prod"}},
+		{cluster: "clone", database: "billing", data: map[string]string{"service": "billing", "env": "prod"}},
+	}
+
+	for _, d := range databases {
+		db := &DbConfig{Cluster: d.cluster, Database: d.database, ObjectsOwner: "owner"}
+		if d.disabled {
+			disabled := true
+			db.Disabled = &disabled
+		}
+
+		if err := storeDbEntry(ctx, storage, d.cluster, d.database, db); err != nil {
+			t.Fatalf("failed to store database. %s", err)
+		}
+
+		meta := &Metadata{Cluster: d.cluster, Database: d.database, Data: d.data, Deleted: d.disabled}
+		if err := storeMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID(d.cluster, d.database)), meta); err != nil {
+			t.Fatalf("failed to store metadata. %s", err)
+		}
+	}
+
+	// Client certificate roles fail after the database is resolved when no CA is configured
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + testRole,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"credential_type": credentialTypeClientCert,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write role. err: %v, resp: %v", err, resp)
+	}
+
+	cases := map[string]string{
+		"":                           "'selector' attribute is required",
+		"service in (orders":         "invalid selector",
+		"service=search":             "does not match any active database",
+		"service=billing, env=prod":  "matches multiple active databases: clone/billing, " + testCluster + "/billing",
+		"service=orders, env=prod":   "no client CA is configured for cluster clone",
+		"service in (orders, audit)": "no client CA is configured for cluster clone",
+	}
+
+	for sel, expect := range cases {
+		data := map[string]interface{}{}
+		if sel != "" {
+			data["selector"] = sel
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds-by-selector/" + testRole,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatalf("%q: unexpected error. %s", sel, err)
+		}
+
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), expect) {
+			t.Fatalf("%q: expected error containing %q, got %+v", sel, expect, resp)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package backend
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"strings"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/dbtxn"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/lib/pq"
 )
@@ -29,11 +31,31 @@ var defaultRevocationSQL = []string{
 	"drop role if exists {{user}}",
 }
 
+var defaultRenewSQL = []string{
+	queryRenewExpiry,
+}
+
+// Template variables available to the role statements
+var (
+	creationTemplateVars   = []string{"user", "password", "expiration", "database", "objects_owner", "group"}
+	revocationTemplateVars = []string{"user", "database", "objects_owner", "group"}
+	renewTemplateVars      = []string{"user", "expiration", "database", "objects_owner", "group"}
+)
+
 func (b *backend) secretCredsCreate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	clusterName := data.Get("cluster").(string)
+	clusterName, err := resolveClusterName(ctx, req.Storage, data.Get("cluster").(string))
+	if err != nil {
+		return nil, err
+	}
 	databaseName := data.Get("database").(string)
 	roleName := data.Get("role").(string)
 
+	return b.createDatabaseCreds(ctx, req, data, clusterName, databaseName, roleName)
+}
+
+// createDatabaseCreds issues credentials of the role in the database. The
+// "ttl" and "groups" of the request are read from data.
+func (b *backend) createDatabaseCreds(ctx context.Context, req *logical.Request, data *framework.FieldData, clusterName, databaseName, roleName string) (*logical.Response, error) {
 	cluster, err := loadClusterEntry(ctx, req.Storage, clusterName)
 	if err == ErrNotFound {
 		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is not configured", clusterName)), nil
@@ -65,32 +87,71 @@ func (b *backend) secretCredsCreate(ctx context.Context, req *logical.Request, d
 		return logical.ErrorResponse(fmt.Sprintf("Role %s is not configured", roleName)), nil
 	}
 
-	displayName := req.DisplayName
-	if len(displayName) > 26 {
-		displayName = displayName[:26]
-	}
-	userUUID, err := uuid.GenerateUUID()
 	if err != nil {
 		return nil, err
 	}
-	username := fmt.Sprintf("%s-%s", displayName, userUUID)
-	if len(username) > 63 {
-		username = username[:63]
+
+	if role.IsClusterScoped() {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s spans multiple databases, request credentials using creds/%s/%s", roleName, clusterName, roleName)), nil
 	}
 
-	password, err := uuid.GenerateUUID()
+	username, password, err := generateCredentials(req.DisplayName)
 	if err != nil {
 		return nil, err
 	}
 
-	ttl, _, err := framework.CalculateTTL(b.System(), 0, role.GetDefaultTTL(), 0, role.GetDefaultTTL(), 0, time.Time{})
+	if role.IsEntityUser() {
+		if req.EntityID == "" {
+			return logical.ErrorResponse(fmt.Sprintf("Role %s issues persistent users for Vault entities, the request is not associated with an entity", roleName)), nil
+		}
+
+		username = entityUsername(req.EntityID)
+	}
+
+	ttl := role.GetDefaultTTL()
+	if requestedTTL := time.Duration(data.Get("ttl").(int)) * time.Second; requestedTTL > 0 {
+		ttl = requestedTTL
+	}
+
+	maxTTL := b.credsMaxTTL(role, cluster, database)
+	ttl, warnings, err := framework.CalculateTTL(b.System(), 0, ttl, 0, maxTTL, 0, time.Time{})
 	if err != nil {
 		return nil, err
 	}
 
-	expiration := time.Now().Add(ttl).Format("2006-01-02 15:04:05-0700")
+	expiration := formatExpiration(time.Now().Add(ttl))
 
-	db, err := b.getConn(ctx, req.Storage, connTypeMgmt, clusterName, databaseName)
+	groups := strutil.RemoveDuplicates(data.Get("groups").([]string), false)
+	if err := checkAllowedGroups(roleName, role, groups); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp, err := b.checkRootExecution(ctx, req, roleName, role)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	var ca *ClientCA
+	var cert *ClientCertificate
+	if role.IsClientCertificate() {
+		ca, err = getClientCA(ctx, req.Storage, cluster)
+		if err == ErrNotFound {
+			return logical.ErrorResponse(fmt.Sprintf("Role %s issues client certificates but no client CA is configured for cluster %s or the mount", roleName, clusterName)), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err = ca.issueClientCertificate(username, time.Now().Add(ttl))
+		if err != nil {
+			return nil, err
+		}
+
+		password = ""
+	}
+
+	db, err := b.getConn(ctx, req.Storage, role.GetConnType(), clusterName, databaseName)
 	if err != nil {
 		return nil, err
 	}
@@ -98,6 +159,11 @@ func (b *backend) secretCredsCreate(ctx context.Context, req *logical.Request, d
 		_ = db.Close()
 	}()
 
+	resp, err = checkGroupsExist(ctx, db, groups)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
@@ -110,11 +176,30 @@ func (b *backend) secretCredsCreate(ctx context.Context, req *logical.Request, d
 		"user":          pq.QuoteIdentifier(username),
 		"password":      password,
 		"expiration":    expiration,
+		"database":      pq.QuoteIdentifier(databaseName),
 		"objects_owner": pq.QuoteIdentifier(database.ObjectsOwner),
 		"group":         pq.QuoteIdentifier(cluster.ManagementRole),
 	}
 
-	for _, query := range role.CreationStatement {
+	if err := protectPassword(ctx, tx, m); err != nil {
+		return nil, err
+	}
+
+	creation := role.GetCreationStatement()
+	if role.IsEntityUser() {
+		// The existence of user is checked and the user is created while
+		// holding the lock until the transaction is committed
+		b.entityLoginLock.Lock()
+		defer b.entityLoginLock.Unlock()
+
+		creation, err = entityLoginStatements(ctx, db, role, username)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	grantGroups, _ := groupStatements(groups)
+	for _, query := range append(creation, grantGroups...) {
 		query = strings.TrimSpace(query)
 		if len(query) == 0 {
 			continue
@@ -125,7 +210,42 @@ func (b *backend) secretCredsCreate(ctx context.Context, req *logical.Request, d
 		}
 	}
 
+	if role.AssumeObjectsOwner {
+		if err := checkCanAssumeRole(ctx, tx, username, database.ObjectsOwner); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("Role %s is configured to assume the objects owner. %s", roleName, err)), nil
+		}
+	}
+
+	leaseRef, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &LeaseEntry{
+		Username: username,
+		Cluster:  clusterName,
+		Database: databaseName,
+	}
+
+	err = storeLeaseEntry(ctx, req.Storage, roleName, leaseRef, lease)
+	if err != nil {
+		return nil, err
+	}
+
+	grants := &EntityLease{Groups: groups, ObjectsOwner: database.ObjectsOwner}
+	if role.IsEntityUser() {
+		err = b.trackEntityLease(ctx, req.Storage, req.EntityID, clusterName, databaseName, username, leaseRef, grants)
+		if err != nil {
+			_ = deleteLeaseEntry(ctx, req.Storage, roleName, leaseRef)
+			return nil, err
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
+		_ = deleteLeaseEntry(ctx, req.Storage, roleName, leaseRef)
+		if role.IsEntityUser() {
+			_, _, _ = b.releaseEntityLease(ctx, req.Storage, clusterName, username, leaseRef, grants)
+		}
 		return nil, err
 	}
 
@@ -134,20 +254,64 @@ func (b *backend) secretCredsCreate(ctx context.Context, req *logical.Request, d
 		"password": password,
 	}
 
+	if cert != nil {
+		delete(sec, "password")
+		sec["certificate"] = cert.Certificate
+		sec["private_key"] = cert.PrivateKey
+		sec["private_key_type"] = "ec"
+		sec["issuing_ca"] = ca.CertPEM
+		sec["serial_number"] = cert.SerialNumber
+	}
+
 	internalSec := map[string]interface{}{
-		"role":     roleName,
-		"username": username,
-		"cluster":  clusterName,
-		"database": databaseName,
+		"role":                 roleName,
+		"username":             username,
+		"cluster":              clusterName,
+		"database":             databaseName,
+		"lease_ref":            leaseRef,
+		"role_version":         role.Version,
+		"execute_as":           role.GetConnType().String(),
+		"revocation_statement": role.RevocationStatement,
+		"groups":               groups,
+		"user_type":            role.GetUserType(),
+		"revocation_mode":      role.GetRevocationMode(),
+		"revocation_retention": role.GetRevocationRetention().String(),
+		"credential_type":      role.GetCredentialType(),
+	}
+
+	if len(groups) > 0 {
+		sec["groups"] = groups
+	}
+
+	// The internal data is copied into the lease by Response
+	if cert != nil {
+		internalSec["serial_number"] = cert.SerialNumber
+	}
+
+	resp = b.Secret(SecretCredsType).Response(sec, internalSec)
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = maxTTL
+
+	// The expiry of a certificate cannot be extended
+	if cert != nil {
+		resp.Secret.Renewable = false
+	}
+	resp.Warnings = warnings
+
+	if role.GetConnType() == connTypeRoot {
+		b.Logger().Warn("issued credentials using root connection", "role", roleName, "cluster", clusterName,
+			"database", databaseName, "username", username, "display_name", req.DisplayName, "entity_id", req.EntityID)
+		resp.AddWarning(fmt.Sprintf("Credentials were created using the root connection of cluster %s", clusterName))
 	}
 
-	resp := b.Secret(SecretCredsType).Response(sec, internalSec)
-	resp.Secret.TTL = role.GetDefaultTTL()
-	resp.Secret.MaxTTL = role.GetMaxTTL()
 	return resp, nil
 }
 
 func (b *backend) secretCredsRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if _, ok := req.Secret.InternalData["databases"]; ok {
+		return b.secretClusterCredsRenew(ctx, req, data)
+	}
+
 	roleName, err := getInternalStr("role", req.Secret.InternalData)
 	if err != nil {
 		return nil, err
@@ -203,15 +367,28 @@ func (b *backend) secretCredsRenew(ctx context.Context, req *logical.Request, da
 		return logical.ErrorResponse(fmt.Sprintf("Database %s is marked as deleted. Cannot renew credentials", databaseName)), nil
 	}
 
-	ttl, warnings, err := framework.CalculateTTL(b.System(), req.Secret.Increment, role.GetDefaultTTL(), 0, role.GetMaxTTL(), role.GetMaxTTL(), req.Secret.IssueTime)
+	maxTTL := b.credsMaxTTL(role, cluster, database)
+	ttl, warnings, err := framework.CalculateTTL(b.System(), req.Secret.Increment, role.GetDefaultTTL(), 0, maxTTL, maxTTL, req.Secret.IssueTime)
+	if err != nil {
+		return nil, err
+	}
+
 	if ttl > 0 {
-		expiration := time.Now().UTC().Add(ttl).Add(5 * time.Second).Format("2006-01-02 15:04:05+00")
+		expiration := formatExpiration(time.Now().Add(ttl).Add(5 * time.Second))
 		m := map[string]string{
-			"user":       pq.QuoteIdentifier(username),
-			"expiration": expiration,
+			"user":          pq.QuoteIdentifier(username),
+			"expiration":    expiration,
+			"database":      pq.QuoteIdentifier(databaseName),
+			"objects_owner": pq.QuoteIdentifier(database.ObjectsOwner),
+			"group":         pq.QuoteIdentifier(cluster.ManagementRole),
+		}
+
+		resp, err := b.checkRootExecution(ctx, req, roleName, role)
+		if resp != nil || err != nil {
+			return resp, err
 		}
 
-		db, err := b.getConn(ctx, req.Storage, connTypeMgmt, clusterName, databaseName)
+		db, err := b.getConn(ctx, req.Storage, role.GetConnType(), clusterName, databaseName)
 		if err != nil {
 			return nil, err
 		}
@@ -219,10 +396,37 @@ func (b *backend) secretCredsRenew(ctx context.Context, req *logical.Request, da
 			_ = db.Close()
 		}()
 
-		err = dbtxn.ExecuteDBQuery(ctx, db, m, queryRenewExpiry)
+		exists, err := roleExists(ctx, db, username)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return logical.ErrorResponse(fmt.Sprintf("User %s does not exist in cluster %s. Cannot renew credentials", username, clusterName)), nil
+		}
+
+		tx, err := db.Begin()
 		if err != nil {
 			return nil, err
 		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		for _, query := range role.GetRenewStatement() {
+			query = strings.TrimSpace(query)
+			if len(query) == 0 {
+				continue
+			}
+
+			if err := dbtxn.ExecuteTxQuery(ctx, tx, m, query); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
 	}
 
 	resp := &logical.Response{
@@ -231,11 +435,15 @@ func (b *backend) secretCredsRenew(ctx context.Context, req *logical.Request, da
 	}
 
 	resp.Secret.TTL = ttl
-	resp.Secret.MaxTTL = role.GetMaxTTL()
+	resp.Secret.MaxTTL = maxTTL
 	return resp, nil
 }
 
 func (b *backend) secretCredsRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if _, ok := req.Secret.InternalData["databases"]; ok {
+		return b.secretClusterCredsRevoke(ctx, req, data)
+	}
+
 	roleName, err := getInternalStr("role", req.Secret.InternalData)
 	if err != nil {
 		return nil, err
@@ -258,23 +466,39 @@ func (b *backend) secretCredsRevoke(ctx context.Context, req *logical.Request, d
 
 	resp := &logical.Response{}
 
-	role, err := loadRoleEntry(ctx, req.Storage, roleName)
-	if err != ErrNotFound && err != nil {
+	// Leases carry the revocation statements of the role at the time
+	// of issue. Older leases fall back to the current role statements.
+	revocationSQL, err := getInternalStrSlice("revocation_statement", req.Secret.InternalData)
+	if err != nil {
 		return nil, err
 	}
 
-	var revocationSQL []string
+	connT := connTypeMgmt
+	if executeAs, ok := req.Secret.InternalData["execute_as"].(string); ok {
+		connT, err = parseConnType(executeAs)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	if err == ErrNotFound {
-		resp.AddWarning(fmt.Sprintf("Role %s was not found, using default revocation SQL", roleName))
-		revocationSQL = defaultRevocationSQL
-	} else {
-		revocationSQL = role.RevocationStatement
+	if revocationSQL == nil {
+		role, err := loadRoleEntry(ctx, req.Storage, roleName)
+		if err != ErrNotFound && err != nil {
+			return nil, err
+		}
+
+		if err == ErrNotFound {
+			resp.AddWarning(fmt.Sprintf("Role %s was not found, using default revocation SQL", roleName))
+			revocationSQL = defaultRevocationSQL
+		} else {
+			revocationSQL = role.RevocationStatement
+			connT = role.GetConnType()
+		}
 	}
 
 	cluster, err := loadClusterEntry(ctx, req.Storage, clusterName)
 	if err == ErrNotFound {
-		return logical.ErrorResponse(fmt.Sprintf("Configuration for cluster %s cannot be found", clusterName)), nil
+		return revokeWithoutConfig(ctx, req, roleName, fmt.Sprintf("Configuration for cluster %s cannot be found", clusterName))
 	}
 
 	if err != nil {
@@ -283,7 +507,7 @@ func (b *backend) secretCredsRevoke(ctx context.Context, req *logical.Request, d
 
 	database, err := loadDbEntry(ctx, req.Storage, clusterName, databaseName)
 	if err == ErrNotFound {
-		return logical.ErrorResponse(fmt.Sprintf("Configuration for database %s cannot be found", databaseName)), nil
+		return revokeWithoutConfig(ctx, req, roleName, fmt.Sprintf("Configuration for database %s cannot be found", databaseName))
 	}
 
 	if err != nil {
@@ -297,7 +521,71 @@ func (b *backend) secretCredsRevoke(ctx context.Context, req *logical.Request, d
 		"group":         pq.QuoteIdentifier(cluster.ManagementRole),
 	}
 
-	db, err := b.getConn(ctx, req.Storage, connTypeMgmt, clusterName, databaseName)
+	// Memberships of the requested groups are revoked before the user is dropped
+	groups, err := getInternalStrSlice("groups", req.Secret.InternalData)
+	if err != nil {
+		return nil, err
+	}
+
+	_, revokeGroups := groupStatements(groups)
+
+	// The persistent user of an entity is shared by all leases of the entity
+	// and its login is only disabled when the last lease is revoked. The
+	// groups and the objects owner granted by the lease are revoked right
+	// away unless another lease of the user needs them.
+	leaseRef, _ := req.Secret.InternalData["lease_ref"].(string)
+	if userType, _ := req.Secret.InternalData["user_type"].(string); userType == userTypeEntity {
+		released := &EntityLease{Groups: groups, ObjectsOwner: database.ObjectsOwner}
+		remaining, revoke, err := b.releaseEntityLease(ctx, req.Storage, clusterName, username, leaseRef, released)
+		if err != nil {
+			return nil, err
+		}
+
+		_, revokeGroups = groupStatements(revoke)
+		if remaining > 0 {
+			resp.AddWarning(fmt.Sprintf("User %s has %d other active leases, the login is not disabled", username, remaining))
+
+			if len(revokeGroups) > 0 {
+				err = b.execInDatabase(ctx, req.Storage, connT, clusterName, databaseName, revokeGroups, m, func(idx int, query string, err error) {
+					resp.AddWarning(fmt.Sprintf("failed to run revocation query [%d]: %q - %s", idx, query, err))
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if err := deleteLeaseEntry(ctx, req.Storage, roleName, leaseRef); err != nil {
+				return nil, err
+			}
+
+			return resp, nil
+		}
+	}
+
+	revocationSQL = append(revokeGroups, revocationSQL...)
+
+	if mode, retention := getRevocationMode(req.Secret.InternalData); mode != revocationModeDrop {
+		msg, err := b.revokeByDisabling(ctx, req.Storage, connT, mode, retention, &DisabledUser{
+			Username:            username,
+			Cluster:             clusterName,
+			Database:            databaseName,
+			RevocationStatement: revocationSQL,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resp.AddWarning(msg)
+		if leaseRef != "" {
+			if err := deleteLeaseEntry(ctx, req.Storage, roleName, leaseRef); err != nil {
+				return nil, err
+			}
+		}
+
+		return resp, nil
+	}
+
+	db, err := b.getConn(ctx, req.Storage, connT, clusterName, databaseName)
 	if err != nil {
 		return nil, err
 	}
@@ -328,9 +616,201 @@ func (b *backend) secretCredsRevoke(ctx context.Context, req *logical.Request, d
 		return nil, err
 	}
 
+	if leaseRef != "" {
+		if err := deleteLeaseEntry(ctx, req.Storage, roleName, leaseRef); err != nil {
+			return nil, err
+		}
+	}
+
 	return resp, nil
 }
 
+// checkRootExecution returns an error response if the role is configured to
+// use the root connection but it is not allowed to by the mount config.
+func (b *backend) checkRootExecution(ctx context.Context, req *logical.Request, roleName string, role *RoleConfig) (*logical.Response, error) {
+	if role.GetConnType() != connTypeRoot {
+		return nil, nil
+	}
+
+	mc, err := loadMountConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if !mc.CanExecuteAsRoot(roleName) {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s is not allowed to execute as root", roleName)), nil
+	}
+
+	return nil, nil
+}
+
+// entityLoginStatements returns the statements to enable the login of the
+// persistent user of an entity. The user is created first if it does not exist.
+func entityLoginStatements(ctx context.Context, db *sql.DB, role *RoleConfig, username string) ([]string, error) {
+	exists, err := roleExists(ctx, db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []string
+	if !exists {
+		statements = append(statements, role.CreationStatement...)
+	}
+
+	statements = append(statements, role.LoginStatement...)
+	if role.AssumeObjectsOwner {
+		statements = append(statements, queryAssumeObjectsOwner)
+	}
+
+	return statements, nil
+}
+
+// checkCanAssumeRole returns an error if the user is not a member of the role
+// and therefore cannot set it as the current role.
+func checkCanAssumeRole(ctx context.Context, tx *sql.Tx, username, role string) error {
+	var isMember bool
+	err := tx.QueryRowContext(ctx, queryUserHasRole, username, role).Scan(&isMember)
+	if err != nil {
+		return err
+	}
+
+	if !isMember {
+		return fmt.Errorf("user %s is not a member of %s", username, role)
+	}
+
+	return nil
+}
+
+// checkAllowedGroups returns an error if any of the requested
+// groups is not in the allowed groups of role
+func checkAllowedGroups(roleName string, role *RoleConfig, groups []string) error {
+	for _, g := range groups {
+		if !strutil.StrListContains(role.AllowedGroups, g) {
+			return fmt.Errorf("Group %s is not allowed by role %s", g, roleName)
+		}
+	}
+
+	return nil
+}
+
+// checkGroupsExist returns an error response if any of the
+// requested groups does not exist in the cluster
+func checkGroupsExist(ctx context.Context, db *sql.DB, groups []string) (*logical.Response, error) {
+	for _, g := range groups {
+		exists, err := roleExists(ctx, db, g)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return logical.ErrorResponse(fmt.Sprintf("Group %s does not exist in cluster", g)), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// groupStatements returns the statements to grant and revoke the membership
+// of groups. The group names are quoted here because the statements are
+// rendered using the template variables of the role statements.
+func groupStatements(groups []string) (grant []string, revoke []string) {
+	for _, g := range groups {
+		grant = append(grant, fmt.Sprintf(queryGrantGroup, pq.QuoteIdentifier(g)))
+		revoke = append(revoke, fmt.Sprintf(queryRevokeGroup, pq.QuoteIdentifier(g)))
+	}
+
+	return grant, revoke
+}
+
+// credsMaxTTL returns the maximum TTL of credentials issued for the role
+// in given cluster and databases. The role max_ttl is capped by the ceilings
+// configured on cluster, every database, and the mount.
+func (b *backend) credsMaxTTL(role *RoleConfig, cluster *ClusterConfig, databases ...*DbConfig) time.Duration {
+	ceilings := []time.Duration{role.GetMaxTTL(), cluster.GetMaxCredentialTTL()}
+	for _, database := range databases {
+		ceilings = append(ceilings, database.GetMaxCredentialTTL())
+	}
+
+	maxTTL := b.System().MaxLeaseTTL()
+	for _, ceiling := range ceilings {
+		if ceiling > 0 && ceiling < maxTTL {
+			maxTTL = ceiling
+		}
+	}
+
+	return maxTTL
+}
+
+// generateCredentials returns a new username derived from the display
+// name of the requester and a random password.
+func generateCredentials(displayName string) (string, string, error) {
+	if len(displayName) > 26 {
+		displayName = displayName[:26]
+	}
+
+	userUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", "", err
+	}
+
+	username := fmt.Sprintf("%s-%s", displayName, userUUID)
+	if len(username) > 63 {
+		username = username[:63]
+	}
+
+	password, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", "", err
+	}
+
+	return username, password, nil
+}
+
+// formatExpiration formats the time in UTC as expected by the
+// valid until clause in postgres
+func formatExpiration(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05+00")
+}
+
+// LeaseEntry tracks a lease issued from a role until it is revoked
+type LeaseEntry struct {
+	Username  string   `json:"username"`
+	Cluster   string   `json:"cluster"`
+	Database  string   `json:"database"`
+	Databases []string `json:"databases,omitempty"`
+}
+
+func storeLeaseEntry(ctx context.Context, storage logical.Storage, roleName, ref string, lease *LeaseEntry) error {
+	entry, err := logical.StorageEntryJSON(PathLease.For(roleName, ref), lease)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, entry)
+}
+
+// revokeWithoutConfig fails the revocation of a lease whose cluster or database
+// configuration no longer exists. The lease entry is deleted anyway since the
+// user can never be revoked by the backend, and a stale entry would otherwise
+// block the deletion of the role.
+func revokeWithoutConfig(ctx context.Context, req *logical.Request, roleName, msg string) (*logical.Response, error) {
+	if leaseRef, ok := req.Secret.InternalData["lease_ref"].(string); ok && leaseRef != "" {
+		if err := deleteLeaseEntry(ctx, req.Storage, roleName, leaseRef); err != nil {
+			return nil, err
+		}
+	}
+
+	return logical.ErrorResponse(msg), nil
+}
+
+func deleteLeaseEntry(ctx context.Context, storage logical.Storage, roleName, ref string) error {
+	return storage.Delete(ctx, PathLease.For(roleName, ref))
+}
+
+func listLeaseEntries(ctx context.Context, storage logical.Storage, roleName string) ([]string, error) {
+	return storage.List(ctx, PathLease.For(roleName, ""))
+}
+
 func getInternalStr(key string, data map[string]interface{}) (string, error) {
 	vr, ok := data[key]
 	if !ok {
@@ -344,3 +824,30 @@ func getInternalStr(key string, data map[string]interface{}) (string, error) {
 
 	return val, nil
 }
+
+// getInternalStrSlice returns nil without error if the key is not set.
+// The internal data is JSON encoded in storage so the value is usually
+// decoded as a slice of interface values.
+func getInternalStrSlice(key string, data map[string]interface{}) ([]string, error) {
+	vr, ok := data[key]
+	if !ok || vr == nil {
+		return nil, nil
+	}
+
+	switch val := vr.(type) {
+	case []string:
+		return val, nil
+	case []interface{}:
+		result := make([]string, 0, len(val))
+		for _, v := range val {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("raw value for %s internal data is not a list of strings", key)
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("raw value for %s internal data is not a list of strings", key)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	logicaltest "github.com/hashicorp/vault/helper/testhelpers/logical"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lib/pq"
 	"github.com/mitchellh/mapstructure"
 	"path"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -77,10 +79,598 @@ func TestAccCredsCreate(t *testing.T) {
 			testAccWriteRoleConfig(t, path.Join("roles", testRole), rolesAttr, false),
 			testAccReadRoleConfigCopy(t, path.Join("roles", testRole), testStorage),
 			testAccReadCreds(hijackT, cluster, backend, testStorage),
+			testAccWriteCredsTTL(t, 30, 30*time.Second),
+			testAccWriteCredsTTL(t, 3600, testCredsMaxTTL*time.Second),
 		},
 	})
 }
 
+func TestCredsMaxTTL(t *testing.T) {
+	b := testGetBackend(t).(*backend)
+
+	role := &RoleConfig{DefaultTTL: 60, MaxTTL: 600}
+	cluster := &ClusterConfig{}
+	database := &DbConfig{}
+
+	if got := b.credsMaxTTL(role, cluster, database); got != 600*time.Second {
+		t.Fatalf("expected role max_ttl to apply, got %s", got)
+	}
+
+	cluster.MaxCredentialTTL = 300
+	if got := b.credsMaxTTL(role, cluster, database); got != 300*time.Second {
+		t.Fatalf("expected cluster ceiling to apply, got %s", got)
+	}
+
+	database.MaxCredentialTTL = 120
+	if got := b.credsMaxTTL(role, cluster, database); got != 120*time.Second {
+		t.Fatalf("expected database ceiling to apply, got %s", got)
+	}
+
+	other := &DbConfig{MaxCredentialTTL: 90}
+	if got := b.credsMaxTTL(role, cluster, database, other); got != 90*time.Second {
+		t.Fatalf("expected lowest database ceiling to apply, got %s", got)
+	}
+
+	role.MaxTTL = 0
+	cluster.MaxCredentialTTL = 0
+	database.MaxCredentialTTL = 0
+	if got := b.credsMaxTTL(role, cluster, database); got != b.System().MaxLeaseTTL() {
+		t.Fatalf("expected mount max lease TTL to apply, got %s", got)
+	}
+}
+
+func TestAccCredsCreate_cluster(t *testing.T) {
+	backend := testGetBackend(t)
+	cleanup, clusterAttr := prepareTestContainer(t)
+	defer cleanup()
+
+	cluster := &ClusterConfig{}
+	cb := func(c *ClusterConfig) error {
+		*cluster = *c
+		return nil
+	}
+
+	logicaltest.Test(t, logicaltest.TestCase{
+		LogicalBackend: backend,
+		Steps: []logicaltest.TestStep{
+			testAccWriteClusterConfig(t, path.Join("cluster", testCluster), clusterAttr, false),
+			testAccReadClusterConfigCallback(t, path.Join("cluster", testCluster), cb),
+			testAccWriteDbConfig(t, path.Join("cluster", testCluster, "test-db-one")),
+			testAccWriteDbConfig(t, path.Join("cluster", testCluster, "test-db-two")),
+			testAccWriteDbConfig(t, path.Join("cluster", testCluster, "test-db-three")),
+			testAccWriteDbMetadata(t, testCluster, "test-db-two", map[string]interface{}{"reporting": "true"}),
+			testAccWriteRoleConfig(t, path.Join("roles", testRole), map[string]interface{}{
+				"databases":         "test-db-one",
+				"database_selector": "reporting=true",
+			}, false),
+
+			// Database scoped credentials cannot be issued for the role
+			{
+				Operation: logical.ReadOperation,
+				Path:      path.Join("creds", testCluster, "test-db-one", testRole),
+				ErrorOk:   true,
+				Check:     checkErrResponse,
+			},
+			{
+				Operation: logical.ReadOperation,
+				Path:      path.Join("creds", testCluster, testRole),
+				Check:     testAccCheckClusterCreds(cluster, []string{"test-db-one", "test-db-two"}, []string{"test-db-three"}),
+			},
+		},
+	})
+}
+
+func TestAccCredsCreate_privileges(t *testing.T) {
+	backend := testGetBackend(t)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := backend.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed. err: %v, resp: %v", op, path, err, resp)
+		}
+
+		return resp
+	}
+
+	request(logical.UpdateOperation, path.Join("cluster", testCluster), attr)
+	request(logical.UpdateOperation, path.Join("cluster", testCluster, testDb), map[string]interface{}{
+		"objects_owner_role": "test_privileges_owner",
+	})
+
+	cluster, err := loadClusterEntry(ctx, storage, testCluster)
+	if err != nil {
+		t.Fatalf("failed to load cluster. %s", err)
+	}
+
+	root, err := sql.Open("postgres", cluster.dsnForDb(connTypeRoot, testDb))
+	if err != nil {
+		t.Fatalf("failed to open database connection. %s", err)
+	}
+	defer root.Close()
+
+	ownerExec := func(query string) {
+		_, err := root.Exec(fmt.Sprintf("set role test_privileges_owner; %s; reset role", query))
+		if err != nil {
+			t.Fatalf("failed to execute %q as objects owner. %s", query, err)
+		}
+	}
+
+	if _, err := root.Exec("create schema app authorization test_privileges_owner"); err != nil {
+		t.Fatalf("failed to create schema. %s", err)
+	}
+
+	ownerExec("create table app.items (id int)")
+	ownerExec("insert into app.items values (1)")
+
+	request(logical.UpdateOperation, "roles/test-privileges", map[string]interface{}{
+		"privileges": []interface{}{
+			map[string]interface{}{
+				"schemas":        []interface{}{"app"},
+				"object_types":   []interface{}{"tables"},
+				"privileges":     []interface{}{"select"},
+				"include_future": true,
+			},
+		},
+	})
+
+	resp := request(logical.ReadOperation, path.Join("creds", testCluster, testDb, "test-privileges"), nil)
+	u, p := resp.Data["username"].(string), resp.Data["password"].(string)
+
+	// Tables created after the user are covered by the default privileges
+	ownerExec("create table app.later (id int)")
+
+	conn, err := sql.Open("postgres", cluster.dsnForUser(u, p, testDb))
+	if err != nil {
+		t.Fatalf("failed to connect using issued creds. %s", err)
+	}
+	defer conn.Close()
+
+	for _, table := range []string{"app.items", "app.later"} {
+		var count int
+		if err := conn.QueryRow(fmt.Sprintf("select count(*) from %s", table)).Scan(&count); err != nil {
+			t.Fatalf("failed to read %s as issued user. %s", table, err)
+		}
+	}
+
+	if _, err := conn.Exec("insert into app.items values (2)"); err == nil {
+		t.Fatalf("expected issued user to be denied writes")
+	}
+	_ = conn.Close()
+
+	revResp, err := backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   storage,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (revResp != nil && revResp.IsError()) {
+		t.Fatalf("failed to revoke lease. err: %v, resp: %v", err, revResp)
+	}
+
+	var exists bool
+	if err := root.QueryRow(queryRoleExists, u).Scan(&exists); err != nil {
+		t.Fatalf("failed to check user. %s", err)
+	}
+
+	if exists {
+		t.Fatalf("expected user %s to be dropped on revoke", u)
+	}
+}
+
+func TestAccCredsCreate_assumeObjectsOwner(t *testing.T) {
+	backend := testGetBackend(t)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	request := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return backend.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	mustRequest := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := request(op, path, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed. err: %v, resp: %v", op, path, err, resp)
+		}
+
+		return resp
+	}
+
+	mustRequest(logical.UpdateOperation, path.Join("cluster", testCluster), attr)
+	mustRequest(logical.UpdateOperation, path.Join("cluster", testCluster, testDb), map[string]interface{}{
+		"objects_owner_role": "test_assume_owner",
+	})
+	mustRequest(logical.UpdateOperation, "roles/test-assume", map[string]interface{}{
+		"assume_objects_owner": true,
+	})
+
+	cluster, err := loadClusterEntry(ctx, storage, testCluster)
+	if err != nil {
+		t.Fatalf("failed to load cluster. %s", err)
+	}
+
+	resp := mustRequest(logical.ReadOperation, path.Join("creds", testCluster, testDb, "test-assume"), nil)
+	u, p := resp.Data["username"].(string), resp.Data["password"].(string)
+
+	conn, err := sql.Open("postgres", cluster.dsnForUser(u, p, testDb))
+	if err != nil {
+		t.Fatalf("failed to connect using issued creds. %s", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Exec("create table assumed (id int)"); err != nil {
+		t.Fatalf("failed to create table as issued user. %s", err)
+	}
+
+	var owner string
+	if err := conn.QueryRow("select tableowner from pg_tables where tablename = 'assumed'").Scan(&owner); err != nil {
+		t.Fatalf("failed to read table owner. %s", err)
+	}
+
+	if owner != "test_assume_owner" {
+		t.Fatalf("expected table to be owned by the objects owner, got %s", owner)
+	}
+	_ = conn.Close()
+
+	revResp, err := backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   storage,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (revResp != nil && revResp.IsError()) {
+		t.Fatalf("failed to revoke lease. err: %v, resp: %v", err, revResp)
+	}
+
+	// Creation statements that do not grant the objects owner are refused
+	mustRequest(logical.UpdateOperation, "roles/test-assume-denied", map[string]interface{}{
+		"assume_objects_owner": true,
+		"creation_statement":   "create role {{user}} with login password '{{password}}' valid until '{{expiration}}' role {{group}}",
+	})
+
+	resp, err = request(logical.ReadOperation, path.Join("creds", testCluster, testDb, "test-assume-denied"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error. %s", err)
+	}
+
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "assume the objects owner") {
+		t.Fatalf("expected creds to be refused, got %+v", resp)
+	}
+}
+
+func TestAccCredsCreate_groups(t *testing.T) {
+	backend := testGetBackend(t)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := backend.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed. err: %v, resp: %v", op, path, err, resp)
+		}
+
+		return resp
+	}
+
+	request(logical.UpdateOperation, path.Join("cluster", testCluster), attr)
+	request(logical.UpdateOperation, path.Join("cluster", testCluster, testDb), nil)
+
+	cluster, err := loadClusterEntry(ctx, storage, testCluster)
+	if err != nil {
+		t.Fatalf("failed to load cluster. %s", err)
+	}
+
+	root, err := sql.Open("postgres", cluster.dsnForDb(connTypeRoot, testDb))
+	if err != nil {
+		t.Fatalf("failed to open database connection. %s", err)
+	}
+	defer root.Close()
+
+	for _, query := range []string{
+		"create role test_readers nologin",
+		"create table revoke_log (groups int)",
+		"grant insert on revoke_log to public",
+	} {
+		if _, err := root.Exec(query); err != nil {
+			t.Fatalf("failed to execute %q. %s", query, err)
+		}
+	}
+
+	// The revocation statements log the memberships of the user in the
+	// group, which must already be revoked when they run
+	revocation := append([]string{
+		"set role {{user}}",
+		"insert into revoke_log select count(*) from pg_roles where rolname = 'test_readers' and pg_has_role(current_user, oid, 'member')",
+		"reset role",
+	}, defaultRevocationSQL...)
+
+	request(logical.UpdateOperation, "roles/test-groups", map[string]interface{}{
+		"allowed_groups":       "test_readers",
+		"revocation_statement": revocation,
+	})
+
+	resp := request(logical.UpdateOperation, path.Join("creds", testCluster, testDb, "test-groups"), map[string]interface{}{
+		"groups": "test_readers",
+	})
+	u := resp.Data["username"].(string)
+
+	var member bool
+	if err := root.QueryRow(queryUserHasRole, u, "test_readers").Scan(&member); err != nil {
+		t.Fatalf("failed to check membership. %s", err)
+	}
+
+	if !member {
+		t.Fatalf("expected user %s to be granted the requested group", u)
+	}
+
+	revResp, err := backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   storage,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (revResp != nil && revResp.IsError()) {
+		t.Fatalf("failed to revoke lease. err: %v, resp: %v", err, revResp)
+	}
+
+	var logged []int
+	rows, err := root.Query("select groups from revoke_log")
+	if err != nil {
+		t.Fatalf("failed to read revoke log. %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var groups int
+		if err := rows.Scan(&groups); err != nil {
+			t.Fatalf("failed to read revoke log. %s", err)
+		}
+		logged = append(logged, groups)
+	}
+
+	if !reflect.DeepEqual([]int{0}, logged) {
+		t.Fatalf("expected the group to be revoked before the revocation statements, got %v", logged)
+	}
+
+	var exists bool
+	if err := root.QueryRow(queryRoleExists, u).Scan(&exists); err != nil {
+		t.Fatalf("failed to check user. %s", err)
+	}
+
+	if exists {
+		t.Fatalf("expected user %s to be dropped on revoke", u)
+	}
+}
+
+func TestAccCredsRenew_userDropped(t *testing.T) {
+	backend := testGetBackend(t)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := backend.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed. err: %v, resp: %v", op, path, err, resp)
+		}
+
+		return resp
+	}
+
+	request(logical.UpdateOperation, path.Join("cluster", testCluster), attr)
+	request(logical.UpdateOperation, path.Join("cluster", testCluster, testDb), nil)
+	request(logical.UpdateOperation, path.Join("roles", testRole), nil)
+
+	cluster, err := loadClusterEntry(ctx, storage, testCluster)
+	if err != nil {
+		t.Fatalf("failed to load cluster. %s", err)
+	}
+
+	resp := request(logical.ReadOperation, path.Join("creds", testCluster, testDb, testRole), nil)
+	u := resp.Data["username"].(string)
+
+	root, err := sql.Open("postgres", cluster.dsnForDb(connTypeRoot, testDb))
+	if err != nil {
+		t.Fatalf("failed to open database connection. %s", err)
+	}
+	defer root.Close()
+
+	if _, err := root.Exec(fmt.Sprintf("drop role %s", pq.QuoteIdentifier(u))); err != nil {
+		t.Fatalf("failed to drop user. %s", err)
+	}
+
+	resp.Secret.IssueTime = time.Now()
+	renResp, err := backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   storage,
+		Secret:    resp.Secret,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on renew. %s", err)
+	}
+
+	if renResp == nil || !renResp.IsError() || !strings.Contains(renResp.Error().Error(), "does not exist") {
+		t.Fatalf("expected renew of dropped user to fail, got %+v", renResp)
+	}
+}
+
+func testAccCheckClusterCreds(cluster *ClusterConfig, allowed, denied []string) logicaltest.TestCheckFunc {
+	return func(resp *logical.Response) error {
+		if resp == nil || resp.Secret == nil {
+			return fmt.Errorf("no secrets available in response")
+		}
+
+		var creds struct {
+			Username  string   `mapstructure:"username"`
+			Password  string   `mapstructure:"password"`
+			Databases []string `mapstructure:"databases"`
+		}
+
+		if err := mapstructure.Decode(resp.Data, &creds); err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(creds.Databases, allowed) {
+			return fmt.Errorf("expected databases %v, got %v", allowed, creds.Databases)
+		}
+
+		connect := func(db string) error {
+			conn, err := sql.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
+				creds.Username, creds.Password, cluster.Host, cluster.Port, db))
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			_, err = conn.Exec(`create table testing (name varchar(64))`)
+			return err
+		}
+
+		for _, db := range allowed {
+			if err := connect(db); err != nil {
+				return fmt.Errorf("failed to create table in database %s using provisioned creds: %s", db, err)
+			}
+		}
+
+		for _, db := range denied {
+			if err := connect(db); err == nil {
+				return fmt.Errorf("expected creds to be denied in database %s", db)
+			}
+		}
+
+		return nil
+	}
+}
+
+func TestCredsCreate_groupNotAllowed(t *testing.T) {
+	backend := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	err := storeClusterEntry(ctx, storage, testCluster, &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"})
+	if err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	err = storeDbEntry(ctx, storage, testCluster, testDb, &DbConfig{Cluster: testCluster, Database: testDb, ObjectsOwner: "owner"})
+	if err != nil {
+		t.Fatalf("failed to store database. %s", err)
+	}
+
+	resp, err := backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      path.Join("roles", testRole),
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"allowed_groups": "billing_ro,orders_ro",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write role. err: %v, resp: %v", err, resp)
+	}
+
+	resp, err = backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      path.Join("creds", testCluster, testDb, testRole),
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"groups": "billing_ro,admin",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error. %s", err)
+	}
+
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "admin") {
+		t.Fatalf("expected group admin to be rejected, got %v", resp)
+	}
+}
+
+func TestCredsRevoke_missingConfig(t *testing.T) {
+	backend := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	resp, err := backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      path.Join("roles", testRole),
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write role. err: %v, resp: %v", err, resp)
+	}
+
+	err = storeLeaseEntry(ctx, storage, testRole, "lease-ref", &LeaseEntry{Username: "user", Cluster: testCluster, Database: testDb})
+	if err != nil {
+		t.Fatalf("failed to store lease. %s", err)
+	}
+
+	resp, err = backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   storage,
+		Secret: &logical.Secret{
+			InternalData: map[string]interface{}{
+				"secret_type": SecretCredsType,
+				"username":    "user",
+				"role":        testRole,
+				"cluster":     testCluster,
+				"database":    testDb,
+				"lease_ref":   "lease-ref",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error. %s", err)
+	}
+
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected revoke to fail without cluster configuration, got %v", resp)
+	}
+
+	leases, err := listLeaseEntries(ctx, storage, testRole)
+	if err != nil {
+		t.Fatalf("failed to list leases. %s", err)
+	}
+
+	if len(leases) != 0 {
+		t.Fatalf("expected lease entry to be deleted, got %v", leases)
+	}
+
+	resp, err = backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      path.Join("roles", testRole),
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete role. err: %v, resp: %v", err, resp)
+	}
+}
+
 func testAccReadCreds(t *T, cluster *ClusterConfig, backend logical.Backend, storage logical.Storage) logicaltest.TestStep {
 	return logicaltest.TestStep{
 		Operation: logical.ReadOperation,
@@ -94,6 +684,28 @@ func testAccReadCreds(t *T, cluster *ClusterConfig, backend logical.Backend, sto
 	}
 }
 
+func testAccWriteCredsTTL(t *testing.T, ttl int, expect time.Duration) logicaltest.TestStep {
+	return logicaltest.TestStep{
+		Operation: logical.UpdateOperation,
+		Path:      path.Join("creds", testCluster, testDb, testRole),
+		Data: map[string]interface{}{
+			"ttl": ttl,
+		},
+		ErrorOk: false,
+		Check: func(resp *logical.Response) error {
+			if resp.Secret == nil {
+				return fmt.Errorf("no secrets available in response")
+			}
+
+			if resp.Secret.TTL != expect {
+				return fmt.Errorf("expected lease TTL %s, got %s", expect, resp.Secret.TTL)
+			}
+
+			return nil
+		},
+	}
+}
+
 func testAccCheckCreds(cluster *ClusterConfig) logicaltest.TestCheckFunc {
 	return func(resp *logical.Response) error {
 		if resp == nil {
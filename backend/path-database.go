@@ -2,30 +2,47 @@ package backend
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/dbtxn"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/lib/pq"
+	"github.com/mitchellh/mapstructure"
+	"time"
 )
 
 type DbConfig struct {
+	Cluster          string `json:"cluster" mapstructure:"cluster"`
+	Database         string `json:"database" mapstructure:"database"`
+	ObjectsOwner     string `json:"objects_owner" mapstructure:"objects_owner"`
+	Disabled         *bool  `json:"disabled" mapstructure:"disabled"`
+	MaxCredentialTTL int    `json:"max_credential_ttl" mapstructure:"max_credential_ttl"`
+}
+
+// walDb records a database registration in progress
+type walDb struct {
 	Cluster      string `json:"cluster" mapstructure:"cluster"`
 	Database     string `json:"database" mapstructure:"database"`
 	ObjectsOwner string `json:"objects_owner" mapstructure:"objects_owner"`
-	Disabled     *bool  `json:"disabled" mapstructure:"disabled"`
+	CreateDb     bool   `json:"create_db" mapstructure:"create_db"`
 }
 
 func (db *DbConfig) AsMap() map[string]interface{} {
 	return map[string]interface{}{
-		"cluster":       db.Cluster,
-		"database":      db.Database,
-		"disabled":      db.IsDisabled(),
-		"objects_owner": db.ObjectsOwner,
+		"cluster":            db.Cluster,
+		"database":           db.Database,
+		"disabled":           db.IsDisabled(),
+		"objects_owner":      db.ObjectsOwner,
+		"max_credential_ttl": db.MaxCredentialTTL,
 	}
 }
 
+func (db *DbConfig) GetMaxCredentialTTL() time.Duration {
+	return time.Duration(db.MaxCredentialTTL) * time.Second
+}
+
 func (db *DbConfig) IsDisabled() bool {
 	if db.Disabled == nil {
 		return false
@@ -75,7 +92,11 @@ func loadDbEntry(ctx context.Context, storage logical.Storage, cluster, db strin
 }
 
 func (b *backend) pathDatabaseUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	cn := data.Get("cluster").(string)
+	cn, err := resolveClusterName(ctx, req.Storage, data.Get("cluster").(string))
+	if err != nil {
+		return nil, err
+	}
+
 	dn := data.Get("database").(string)
 
 	c, err := loadClusterEntry(ctx, req.Storage, cn)
@@ -97,7 +118,7 @@ func (b *backend) pathDatabaseUpdate(ctx context.Context, req *logical.Request,
 	}
 
 	if dbExisting != nil {
-		return logical.ErrorResponse(fmt.Sprintf("Database %s is already registered in cluster %s", dn, cn)), nil
+		return updateDb(ctx, req.Storage, cn, dn, dbExisting, data)
 	}
 
 	objectsOwner := data.Get("objects_owner_role").(string)
@@ -114,17 +135,25 @@ func (b *backend) pathDatabaseUpdate(ctx context.Context, req *logical.Request,
 		objectsOwner = objectsOwner[:63]
 	}
 
+	maxCredentialTTL := data.Get("max_credential_ttl").(int)
+	if maxCredentialTTL < 0 {
+		return logical.ErrorResponse(fmt.Sprintf("Invalid max_credential_ttl %d", maxCredentialTTL)), nil
+	}
+
 	if data.Get("initialize").(bool) {
-		createNewDb := data.Get("create_db").(bool)
-		if err = initializeDb(ctx, req.Storage, b, c, cn, dn, objectsOwner, createNewDb); err != nil {
-			return nil, err
+		resp, err := b.registerDb(ctx, req.Storage, c, cn, dn, objectsOwner, maxCredentialTTL, data)
+		if resp != nil || err != nil {
+			return resp, err
 		}
+
+		return &logical.Response{}, nil
 	}
 
 	dbC := &DbConfig{
-		Cluster:      cn,
-		Database:     dn,
-		ObjectsOwner: objectsOwner,
+		Cluster:          cn,
+		Database:         dn,
+		ObjectsOwner:     objectsOwner,
+		MaxCredentialTTL: maxCredentialTTL,
 	}
 
 	err = storeDbEntry(ctx, req.Storage, cn, dn, dbC)
@@ -135,30 +164,180 @@ func (b *backend) pathDatabaseUpdate(ctx context.Context, req *logical.Request,
 	return &logical.Response{}, nil
 }
 
-func initializeDb(ctx context.Context, storage logical.Storage, b *backend, c *ClusterConfig, cn, dn, objectsOwner string, createNewDb bool) error {
-	clusterConn, err := b.getConn(ctx, storage, connTypeRoot, cn, c.Database)
+// updateDb updates the settings of a registered database that can change
+// after registration, which is only the 'max_credential_ttl'
+func updateDb(ctx context.Context, storage logical.Storage, cn, dn string, dbC *DbConfig, data *framework.FieldData) (*logical.Response, error) {
+	if dbC.IsDisabled() {
+		return logical.ErrorResponse(fmt.Sprintf("Database %s is deleted. Use gc/cluster to manage deleted databases", dn)), nil
+	}
+
+	ttl, ok := data.GetOk("max_credential_ttl")
+	if !ok {
+		return logical.ErrorResponse(fmt.Sprintf("Database %s is already registered in cluster %s", dn, cn)), nil
+	}
+
+	if owner := data.Get("objects_owner_role").(string); owner != "" && owner != dbC.ObjectsOwner {
+		return logical.ErrorResponse(fmt.Sprintf("Objects owner of database %s cannot be changed from %s", dn, dbC.ObjectsOwner)), nil
+	}
+
+	maxCredentialTTL := ttl.(int)
+	if maxCredentialTTL < 0 {
+		return logical.ErrorResponse(fmt.Sprintf("Invalid max_credential_ttl %d", maxCredentialTTL)), nil
+	}
+
+	dbC.MaxCredentialTTL = maxCredentialTTL
+
+	err := storeDbEntry(ctx, storage, cn, dn, dbC)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{}, nil
+}
+
+// registerDb initializes the database in cluster and stores its configuration.
+// The progress is recorded in a WAL entry so that a failed or interrupted
+// registration can either be resumed by a retry or rolled back by vault.
+func (b *backend) registerDb(ctx context.Context, storage logical.Storage, c *ClusterConfig, cn, dn, objectsOwner string, maxCredentialTTL int, data *framework.FieldData) (*logical.Response, error) {
+	pending, walID, err := findDbWAL(ctx, storage, cn, dn)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if pending != nil {
+		requestedOwner := data.Get("objects_owner_role").(string)
+		if requestedOwner != "" && requestedOwner != pending.ObjectsOwner {
+			return logical.ErrorResponse(fmt.Sprintf("Registration of database %s is pending with objects owner %s", dn, pending.ObjectsOwner)), nil
+		}
+	}
+
+	if pending == nil {
+		clusterConn, err := b.getConn(ctx, storage, connTypeRoot, cn, c.Database)
+		if err != nil {
+			return nil, err
+		}
+
+		exists, err := dbExists(ctx, clusterConn, dn)
+		if err != nil {
+			_ = clusterConn.Close()
+			return nil, err
+		}
+
+		ownerExists, err := roleExists(ctx, clusterConn, objectsOwner)
+		_ = clusterConn.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		createNewDb := data.Get("create_db").(bool)
+		if createNewDb && exists {
+			return logical.ErrorResponse(fmt.Sprintf("Database %s already exists in cluster %s. Use create_db=false to register an existing database", dn, cn)), nil
+		}
+
+		if ownerExists {
+			return logical.ErrorResponse(fmt.Sprintf("Role %s already exists in cluster %s", objectsOwner, cn)), nil
+		}
+
+		pending = &walDb{
+			Cluster:      cn,
+			Database:     dn,
+			ObjectsOwner: objectsOwner,
+			CreateDb:     createNewDb,
+		}
+
+		walID, err = framework.PutWAL(ctx, storage, walTypeDatabase, pending)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = initializeDb(ctx, storage, b, c, pending); err != nil {
+		if rbErr := rollbackDb(ctx, storage, b, c, pending); rbErr != nil {
+			b.Logger().Error("failed to rollback database registration", "cluster", cn, "database", dn, "error", rbErr)
+			return nil, fmt.Errorf("failed to initialize database: %s. rollback failed and will be retried: %s", err, rbErr)
+		}
+
+		if walErr := framework.DeleteWAL(ctx, storage, walID); walErr != nil {
+			b.Logger().Warn("failed to delete WAL entry after rollback", "id", walID, "error", walErr)
+		}
+
+		return nil, err
+	}
+
+	dbC := &DbConfig{
+		Cluster:          cn,
+		Database:         dn,
+		ObjectsOwner:     pending.ObjectsOwner,
+		MaxCredentialTTL: maxCredentialTTL,
+	}
+
+	err = storeDbEntry(ctx, storage, cn, dn, dbC)
+	if err != nil {
+		return nil, err
 	}
 
-	dbQV := map[string]string{
-		"database": pq.QuoteIdentifier(dn),
+	if err = framework.DeleteWAL(ctx, storage, walID); err != nil {
+		b.Logger().Warn("failed to delete WAL entry for registered database", "id", walID, "error", err)
 	}
 
-	if createNewDb {
-		err = dbtxn.ExecuteDBQuery(ctx, clusterConn, dbQV, queryCreateDb)
+	return nil, nil
+}
+
+func initializeDb(ctx context.Context, storage logical.Storage, b *backend, c *ClusterConfig, w *walDb) error {
+	clusterConn, err := b.getConn(ctx, storage, connTypeRoot, w.Cluster, c.Database)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = clusterConn.Close()
+	}()
+
+	if w.CreateDb {
+		// A retry may find the database that vault has created in
+		// a previous attempt, in which case it is simply picked up.
+		exists, err := dbExists(ctx, clusterConn, w.Database)
 		if err != nil {
 			return err
 		}
+
+		if !exists {
+			dbQV := map[string]string{
+				"database": pq.QuoteIdentifier(w.Database),
+			}
+
+			err = dbtxn.ExecuteDBQuery(ctx, clusterConn, dbQV, queryCreateDb)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Only the users that are granted access can connect to databases
+		// created by vault, so that the users of roles that span multiple
+		// databases are limited to the selected databases. The management
+		// role grants the access to the users it creates.
+		err = execConnectQueries(ctx, clusterConn, w.Database, c.ManagementRole, queryRevokePublicConnect, queryGrantConnectManagement)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The objects owner is always created by vault, if it exists
+	// then it is left behind by an earlier attempt.
+	ownerExists, err := roleExists(ctx, clusterConn, w.ObjectsOwner)
+	if err != nil {
+		return err
 	}
 
-	dbConn, err := b.getConn(ctx, storage, connTypeMgmt, cn, dn)
+	dbConn, err := b.getConn(ctx, storage, connTypeMgmt, w.Cluster, w.Database)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
 
 	rQV := map[string]string{
-		"role_name":             pq.QuoteIdentifier(objectsOwner),
+		"role_name":             pq.QuoteIdentifier(w.ObjectsOwner),
 		"role_group_management": pq.QuoteIdentifier(c.ManagementRole),
 		"role_group_root":       pq.QuoteIdentifier(c.Username),
 	}
@@ -172,13 +351,165 @@ func initializeDb(ctx context.Context, storage logical.Storage, b *backend, c *C
 	}()
 
 	qSetupRole := []string{queryCreateObjectsOwnerRole, queryGrantAll}
+	if ownerExists {
+		qSetupRole = []string{queryGrantAll}
+	}
+
 	for _, q := range qSetupRole {
 		if err = dbtxn.ExecuteTxQuery(ctx, tx, rQV, q); err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	// The users that are members of the objects owner connect through it
+	if w.CreateDb {
+		return execConnectQueries(ctx, clusterConn, w.Database, w.ObjectsOwner, queryGrantConnect)
+	}
+
+	return nil
+}
+
+// execConnectQueries executes the queries that manage the connect privilege
+// of the role on the database
+func execConnectQueries(ctx context.Context, db *sql.DB, database, role string, queries ...string) error {
+	m := map[string]string{
+		"database":  pq.QuoteIdentifier(database),
+		"role_name": pq.QuoteIdentifier(role),
+	}
+
+	for _, q := range queries {
+		if err := dbtxn.ExecuteDBQuery(ctx, db, m, q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollbackDb reverts the changes made by a registration that did not
+// complete. The database is only dropped if vault was asked to create it.
+func rollbackDb(ctx context.Context, storage logical.Storage, b *backend, c *ClusterConfig, w *walDb) error {
+	clusterConn, err := b.getConn(ctx, storage, connTypeRoot, w.Cluster, c.Database)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = clusterConn.Close()
+	}()
+
+	exists, err := dbExists(ctx, clusterConn, w.Database)
+	if err != nil {
+		return err
+	}
+
+	ownerExists, err := roleExists(ctx, clusterConn, w.ObjectsOwner)
+	if err != nil {
+		return err
+	}
+
+	rQV := map[string]string{
+		"database":  pq.QuoteIdentifier(w.Database),
+		"role_name": pq.QuoteIdentifier(w.ObjectsOwner),
+	}
+
+	if exists && w.CreateDb {
+		if err = dbtxn.ExecuteDBQuery(ctx, clusterConn, rQV, queryDropDb); err != nil {
+			return err
+		}
+	} else if exists && ownerExists {
+		// The database is not ours to drop, only revoke what has
+		// been granted to the objects owner in it.
+		dbConn, err := b.getConn(ctx, storage, connTypeRoot, w.Cluster, w.Database)
+		if err != nil {
+			return err
+		}
+
+		err = dbtxn.ExecuteDBQuery(ctx, dbConn, rQV, queryDropOwned)
+		_ = dbConn.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if ownerExists {
+		return dbtxn.ExecuteDBQuery(ctx, clusterConn, rQV, queryDropRole)
+	}
+
+	return nil
+}
+
+func findDbWAL(ctx context.Context, storage logical.Storage, cn, dn string) (*walDb, string, error) {
+	ids, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, id := range ids {
+		entry, err := framework.GetWAL(ctx, storage, id)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if entry == nil || entry.Kind != walTypeDatabase {
+			continue
+		}
+
+		w := &walDb{}
+		if err = mapstructure.Decode(entry.Data, w); err != nil {
+			return nil, "", err
+		}
+
+		if w.Cluster == cn && w.Database == dn {
+			return w, id, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+func (b *backend) walRollbackDb(ctx context.Context, req *logical.Request, data interface{}) error {
+	w := &walDb{}
+	if err := mapstructure.Decode(data, w); err != nil {
+		return err
+	}
+
+	// Registration completed but the WAL entry was not cleaned up
+	_, err := loadDbEntry(ctx, req.Storage, w.Cluster, w.Database)
+	if err == nil {
+		return nil
+	}
+
+	if err != ErrNotFound {
+		return err
+	}
+
+	c, err := loadClusterEntry(ctx, req.Storage, w.Cluster)
+	if err == ErrNotFound {
+		// Nothing can be done without the cluster configuration
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return rollbackDb(ctx, req.Storage, b, c, w)
+}
+
+func dbExists(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, queryDbExists, name).Scan(&exists)
+	return exists, err
+}
+
+func roleExists(ctx context.Context, db *sql.DB, name string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, queryRoleExists, name).Scan(&exists)
+	return exists, err
 }
 
 func storeDbEntry(ctx context.Context, storage logical.Storage, clusterName, dbName string, db *DbConfig) error {
@@ -191,7 +522,11 @@ func storeDbEntry(ctx context.Context, storage logical.Storage, clusterName, dbN
 }
 
 func (b *backend) pathDatabaseDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	cn := data.Get("cluster").(string)
+	cn, err := resolveClusterName(ctx, req.Storage, data.Get("cluster").(string))
+	if err != nil {
+		return nil, err
+	}
+
 	dn := data.Get("database").(string)
 
 	cEntry, err := req.Storage.Get(ctx, PathCluster.For(cn))
@@ -243,11 +578,20 @@ func (b *backend) pathDatabaseDelete(ctx context.Context, req *logical.Request,
 		return nil, err
 	}
 
+	err = b.markMetadataDeleted(ctx, req.Storage, cn, dn)
+	if err != nil {
+		return nil, err
+	}
+
 	return &logical.Response{}, nil
 }
 
 func (b *backend) pathDatabaseRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	cn := data.Get("cluster").(string)
+	cn, err := resolveClusterName(ctx, req.Storage, data.Get("cluster").(string))
+	if err != nil {
+		return nil, err
+	}
+
 	dn := data.Get("database").(string)
 
 	cEntry, err := req.Storage.Get(ctx, PathCluster.For(cn))
@@ -288,7 +632,11 @@ func (b *backend) pathDatabaseRead(ctx context.Context, req *logical.Request, da
 }
 
 func (b *backend) pathDatabasesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	cluster := data.Get("cluster").(string)
+	cluster, err := resolveClusterName(ctx, req.Storage, data.Get("cluster").(string))
+	if err != nil {
+		return nil, err
+	}
+
 	entries, err := req.Storage.List(ctx, PathDatabase.For(cluster, ""))
 	if err != nil {
 		return nil, err
@@ -1,13 +1,16 @@
 package backend
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	logicaltest "github.com/hashicorp/vault/helper/testhelpers/logical"
+	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/mitchellh/mapstructure"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -60,6 +63,133 @@ func TestAccDatabasesList(t *testing.T) {
 	})
 }
 
+func TestAccDatabaseCreate_rollback(t *testing.T) {
+	backend := testGetBackend(t)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	storage := &failingStorage{Storage: &logical.InmemStorage{}}
+	request := func(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return backend.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	resp, err := request(logical.UpdateOperation, "cluster/test-acc-db", attr)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write cluster. err: %v, resp: %v", err, resp)
+	}
+
+	cluster, err := loadClusterEntry(ctx, storage, "test-acc-db")
+	if err != nil {
+		t.Fatalf("failed to load cluster. %s", err)
+	}
+
+	root, err := sql.Open("postgres", cluster.dsn(connTypeRoot))
+	if err != nil {
+		t.Fatalf("failed to open database connection. %s", err)
+	}
+	defer root.Close()
+
+	dbData := map[string]interface{}{
+		"objects_owner_role": "test_db_owner",
+	}
+
+	// The database is created, then the management role fails to create
+	// the objects owner and the registration is rolled back immediately
+	if _, err := root.Exec(fmt.Sprintf("alter role %s nocreaterole", pq.QuoteIdentifier(cluster.ManagementRole))); err != nil {
+		t.Fatalf("failed to alter management role. %s", err)
+	}
+
+	_, err = request(logical.UpdateOperation, "cluster/test-acc-db/test-db", dbData)
+	if err == nil {
+		t.Fatalf("expected registration to fail without createrole")
+	}
+
+	if _, err := root.Exec(fmt.Sprintf("alter role %s createrole", pq.QuoteIdentifier(cluster.ManagementRole))); err != nil {
+		t.Fatalf("failed to alter management role. %s", err)
+	}
+
+	checkRolledBack(t, ctx, root, storage, "test-db", "test_db_owner")
+
+	// The database and objects owner are created, then storing the
+	// configuration fails and the WAL entry is rolled back by vault
+	storage.failPrefix = PathDatabase.For("test-acc-db", "test-db")
+	_, err = request(logical.UpdateOperation, "cluster/test-acc-db/test-db", dbData)
+	if err == nil {
+		t.Fatalf("expected registration to fail when the configuration cannot be stored")
+	}
+	storage.failPrefix = ""
+
+	var exists bool
+	if err := root.QueryRow(queryRoleExists, "test_db_owner").Scan(&exists); err != nil || !exists {
+		t.Fatalf("expected objects owner to exist before rollback. err: %v", err)
+	}
+
+	wals, err := framework.ListWAL(ctx, storage)
+	if err != nil || len(wals) != 1 {
+		t.Fatalf("expected one WAL entry before rollback, got %v. err: %v", wals, err)
+	}
+
+	_, err = request(logical.RollbackOperation, "", map[string]interface{}{"immediate": true})
+	if err != nil {
+		t.Fatalf("failed to rollback. %s", err)
+	}
+
+	checkRolledBack(t, ctx, root, storage, "test-db", "test_db_owner")
+
+	// Retry must not fail because of a leftover database
+	resp, err = request(logical.UpdateOperation, "cluster/test-acc-db/test-db", dbData)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to register database after rollback. err: %v, resp: %v", err, resp)
+	}
+
+	resp, err = request(logical.ReadOperation, "cluster/test-acc-db/test-db", nil)
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("failed to read database. err: %v, resp: %v", err, resp)
+	}
+
+	if err := testAccCheckValidDbInit(cluster)(resp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingStorage fails to store the entries under failPrefix
+type failingStorage struct {
+	logical.Storage
+	failPrefix string
+}
+
+func (s *failingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if s.failPrefix != "" && strings.HasPrefix(entry.Key, s.failPrefix) {
+		return fmt.Errorf("failed to store %s", entry.Key)
+	}
+
+	return s.Storage.Put(ctx, entry)
+}
+
+func checkRolledBack(t *testing.T, ctx context.Context, root *sql.DB, storage logical.Storage, database, owner string) {
+	t.Helper()
+
+	var exists bool
+	if err := root.QueryRow(queryDbExists, database).Scan(&exists); err != nil || exists {
+		t.Fatalf("expected database %s to be dropped. err: %v", database, err)
+	}
+
+	if err := root.QueryRow(queryRoleExists, owner).Scan(&exists); err != nil || exists {
+		t.Fatalf("expected objects owner %s to be dropped. err: %v", owner, err)
+	}
+
+	wals, err := framework.ListWAL(ctx, storage)
+	if err != nil || len(wals) != 0 {
+		t.Fatalf("expected no WAL entries after rollback, got %v. err: %v", wals, err)
+	}
+}
+
 func testAccListDatabases(t *testing.T, target string, dbs ...string) logicaltest.TestStep {
 	return logicaltest.TestStep{
 		Operation: logical.ListOperation,
@@ -228,3 +358,52 @@ func testAccCheckValidDbInit(cluster *ClusterConfig) logicaltest.TestCheckFunc {
 		return nil
 	}
 }
+
+func TestDatabaseUpdate_maxCredentialTTL(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	if err := storeClusterEntry(ctx, storage, testCluster, &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"}); err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	if err := storeDbEntry(ctx, storage, testCluster, testDb, &DbConfig{Cluster: testCluster, Database: testDb, ObjectsOwner: "owner"}); err != nil {
+		t.Fatalf("failed to store database. %s", err)
+	}
+
+	writes := []struct {
+		data  map[string]interface{}
+		valid bool
+	}{
+		{data: map[string]interface{}{}},
+		{data: map[string]interface{}{"max_credential_ttl": "1h", "objects_owner_role": "other"}},
+		{data: map[string]interface{}{"max_credential_ttl": "1h", "objects_owner_role": "owner"}, valid: true},
+		{data: map[string]interface{}{"max_credential_ttl": "30m"}, valid: true},
+	}
+
+	for i, w := range writes {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "cluster/" + testCluster + "/" + testDb,
+			Storage:   storage,
+			Data:      w.data,
+		})
+		if err != nil {
+			t.Fatalf("write %d: unexpected error. %s", i, err)
+		}
+
+		if w.valid == (resp != nil && resp.IsError()) {
+			t.Fatalf("write %d: expected valid=%t, got %+v", i, w.valid, resp)
+		}
+	}
+
+	db, err := loadDbEntry(ctx, storage, testCluster, testDb)
+	if err != nil {
+		t.Fatalf("failed to load database. %s", err)
+	}
+
+	if db.MaxCredentialTTL != 1800 || db.ObjectsOwner != "owner" {
+		t.Fatalf("unexpected database after update %+v", db)
+	}
+}
@@ -97,8 +97,15 @@ func (b *backend) gcListDatabases(ctx context.Context, req *logical.Request, dat
 }
 
 func (b *backend) gcPurgeCluster(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.aliasLock.Lock()
+	defer b.aliasLock.Unlock()
+
 	cn := data.Get("cluster").(string)
 	cluster, err := loadClusterEntry(ctx, req.Storage, cn)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Cluster with name %s is not registered", cn)), nil
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -107,6 +114,16 @@ func (b *backend) gcPurgeCluster(ctx context.Context, req *logical.Request, data
 		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is not marked for GC. Delete the cluster using cluster/:name endpoint before invoking GC operation on it", cn)), nil
 	}
 
+	resp, err := checkNotAliasTarget(ctx, req.Storage, cn)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	resp, err = checkNoDisabledUsers(ctx, req.Storage, cn, "")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
 	// Also purge cluster's databases
 	databases, err := req.Storage.List(ctx, PathDatabase.For(cn, ""))
 	if err != nil {
@@ -134,6 +151,11 @@ func (b *backend) gcPurgeCluster(ctx context.Context, req *logical.Request, data
 		return nil, err
 	}
 
+	err = b.purgeMetadata(ctx, req.Storage, cn, "")
+	if err != nil {
+		return nil, err
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
 			logical.HTTPContentType: "application/json",
@@ -187,10 +209,35 @@ func (b *backend) gcPurgeDatabase(ctx context.Context, req *logical.Request, dat
 		return logical.ErrorResponse(fmt.Sprintf("Database %s is not marked for GC. Delete the databaes from cluster/:cluster/:database endpoint before invoking GC operation on it", dn)), nil
 	}
 
+	resp, err := checkNoDisabledUsers(ctx, req.Storage, cn, dn)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
 	err = req.Storage.Delete(ctx, PathDatabase.For(cn, dn))
 	if err != nil {
 		return nil, err
 	}
 
+	err = b.purgeMetadata(ctx, req.Storage, cn, dn)
+	if err != nil {
+		return nil, err
+	}
+
 	return &logical.Response{}, nil
 }
+
+// checkNoDisabledUsers refuses the purge while disabled users are waiting to be
+// dropped, since they cannot be dropped once the configuration is gone
+func checkNoDisabledUsers(ctx context.Context, storage logical.Storage, cn, dn string) (*logical.Response, error) {
+	users, err := listPendingDisabledUsers(ctx, storage, cn, dn)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("Disabled users %s have not been dropped yet", strings.Join(users, ", "))), nil
+	}
+
+	return nil, nil
+}
@@ -5,12 +5,169 @@ import (
 	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
 )
 
 type Metadata struct {
 	Cluster  string
 	Database string
 	Data     map[string]string
+
+	// Deleted is set when the object is deleted but not purged yet
+	Deleted bool
+
+	// Version is incremented on every update of Data
+	Version int
+}
+
+// Modes of metadata updates
+const (
+	metadataModeReplace = "replace"
+	metadataModePatch   = "patch"
+)
+
+// CurrentVersion returns the version of the metadata. Entries written
+// before versions were tracked are at version 1.
+func (m *Metadata) CurrentVersion() int {
+	if m.Version < 1 {
+		return 1
+	}
+
+	return m.Version
+}
+
+// Status values of the object that metadata is attached to
+const (
+	metadataStatusActive  = "active"
+	metadataStatusDeleted = "deleted"
+	metadataStatusMissing = "missing"
+)
+
+func clusterMetadataID(cluster string) string {
+	return fmt.Sprintf("cluster/%s", cluster)
+}
+
+// The names of clusters and databases cannot contain a slash,
+// so the ID of database metadata is unambiguous.
+func databaseMetadataID(cluster, database string) string {
+	return fmt.Sprintf("database/%s/%s", cluster, database)
+}
+
+// legacyDatabaseMetadataID is the ID that database metadata was stored
+// at before the cluster and database names were separated by a slash.
+func legacyDatabaseMetadataID(cluster, database string) string {
+	return fmt.Sprintf("database/%s-%s", cluster, database)
+}
+
+// listMetadataIDs returns the IDs of all metadata of the object type,
+// which is either 'cluster' or 'database'.
+func listMetadataIDs(ctx context.Context, storage logical.Storage, target string) ([]string, error) {
+	prefix := target + "/"
+	keys, err := storage.List(ctx, PathMeta.For(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			ids = append(ids, prefix+key)
+			continue
+		}
+
+		nested, err := storage.List(ctx, PathMeta.For(prefix+key))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, n := range nested {
+			ids = append(ids, prefix+key+n)
+		}
+	}
+
+	return ids, nil
+}
+
+// listObjectMetadata returns the metadata of every registered object of the
+// target type, and the metadata left behind by objects that were purged.
+// Objects without metadata are returned with empty data so that selectors
+// with negative requirements can match them.
+func listObjectMetadata(ctx context.Context, storage logical.Storage, target string) ([]*Metadata, error) {
+	ids, err := listMetadataIDs(ctx, storage, target)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*Metadata
+	stored := map[string]bool{}
+	for _, id := range ids {
+		m, err := loadMetadataEntry(ctx, storage, PathMeta.For(id))
+		if err == ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		stored[m.Name()] = true
+		results = append(results, m)
+	}
+
+	clusters, err := storage.List(ctx, PathCluster.For(""))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range clusters {
+		if strings.HasSuffix(c, "/") {
+			// Not an actual cluster path, path belongs to databases inside cluster
+			continue
+		}
+
+		cluster, err := loadClusterEntry(ctx, storage, c)
+		if err != nil {
+			return nil, err
+		}
+
+		if target == "cluster" {
+			if !stored[c] {
+				results = append(results, &Metadata{Cluster: c, Data: map[string]string{}, Deleted: cluster.IsDisabled()})
+			}
+			continue
+		}
+
+		databases, err := storage.List(ctx, PathDatabase.For(c, ""))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range databases {
+			m := &Metadata{Cluster: c, Database: d, Data: map[string]string{}}
+			if stored[m.Name()] {
+				continue
+			}
+
+			database, err := loadDbEntry(ctx, storage, c, d)
+			if err != nil {
+				return nil, err
+			}
+
+			m.Deleted = cluster.IsDisabled() || database.IsDisabled()
+			results = append(results, m)
+		}
+	}
+
+	return results, nil
+}
+
+// ID returns the ID that the metadata of the object is stored at
+func (m *Metadata) ID() string {
+	if m.Database != "" {
+		return databaseMetadataID(m.Cluster, m.Database)
+	}
+
+	return clusterMetadataID(m.Cluster)
 }
 
 func (m *Metadata) Name() string {
@@ -21,6 +178,15 @@ func (m *Metadata) Name() string {
 	return m.Cluster
 }
 
+// Type returns the type of object the metadata is attached to
+func (m *Metadata) Type() string {
+	if m.Database != "" {
+		return "database"
+	}
+
+	return "cluster"
+}
+
 func loadMetadataEntry(ctx context.Context, storage logical.Storage, addr string) (*Metadata, error) {
 	entry, err := storage.Get(ctx, addr)
 	if err != nil {
@@ -55,7 +221,12 @@ func (b *backend) pathMetadataUpdate(ctx context.Context, req *logical.Request,
 		return logical.ErrorResponse("'cluster' attribute is required"), nil
 	}
 
-	_, err := loadClusterEntry(ctx, req.Storage, cluster.(string))
+	cluster, err := resolveClusterName(ctx, req.Storage, cluster.(string))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = loadClusterEntry(ctx, req.Storage, cluster.(string))
 	if err == ErrNotFound {
 		return logical.ErrorResponse(fmt.Sprintf("Cluster with name %s is not registered", cluster)), nil
 	}
@@ -64,7 +235,7 @@ func (b *backend) pathMetadataUpdate(ctx context.Context, req *logical.Request,
 		return nil, err
 	}
 
-	addr := fmt.Sprintf("cluster/%s", cluster)
+	addr := clusterMetadataID(cluster.(string))
 
 	database, ok := data.GetOk("database")
 	if ok {
@@ -77,31 +248,106 @@ func (b *backend) pathMetadataUpdate(ctx context.Context, req *logical.Request,
 			return nil, err
 		}
 
-		addr = fmt.Sprintf("database/%s-%s", cluster, database)
+		addr = databaseMetadataID(cluster.(string), database.(string))
 	}
 
-	meta, err := parseMetaAttr(data)
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	mode := data.Get("mode").(string)
+	removeKeys := data.Get("remove_keys").([]string)
+
+	var meta map[string]string
+	switch mode {
+	case metadataModeReplace:
+		if len(removeKeys) > 0 {
+			return logical.ErrorResponse(fmt.Sprintf("'remove_keys' is only supported in %q mode", metadataModePatch)), nil
+		}
+
+		meta, err = parseMetaAttr(data)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	case metadataModePatch:
+		if _, ok := data.GetOk("data"); ok {
+			meta, err = parseMetaAttr(data)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		} else if len(removeKeys) == 0 {
+			return logical.ErrorResponse("'data' or 'remove_keys' attribute is required"), nil
+		}
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid mode %q, only %q or %q is supported", mode, metadataModeReplace, metadataModePatch)), nil
+	}
+
+	b.metadataLock.Lock()
+	defer b.metadataLock.Unlock()
+
+	current, err := loadMetadataEntry(ctx, req.Storage, PathMeta.For(addr))
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	version := 0
+	var existing map[string]string
+	if current != nil {
+		version = current.CurrentVersion()
+		existing = current.Data
+	}
+
+	if cas, ok := data.GetOk("cas"); ok && cas.(int) != version {
+		return logical.ErrorResponse(fmt.Sprintf("check-and-set parameter %d did not match the current version %d of the metadata", cas, version)), nil
+	}
+
+	if mode == metadataModePatch {
+		merged := make(map[string]string, len(existing)+len(meta))
+		for k, v := range existing {
+			merged[k] = v
+		}
+
+		for _, k := range removeKeys {
+			delete(merged, k)
+		}
+
+		for k, v := range meta {
+			merged[k] = v
+		}
+
+		if len(merged) < 1 {
+			return logical.ErrorResponse("metadata must contain at least one key-value pair after the update, delete the metadata instead"), nil
+		}
+
+		meta = merged
 	}
 
 	entry := &Metadata{
 		Cluster: cluster.(string),
 		Data:    meta,
+		Version: version + 1,
 	}
 
 	if database != nil {
 		entry.Database = database.(string)
 	}
 
-	err = storeMetadataEntry(ctx, req.Storage, PathMeta.For(addr), entry)
+	status, err := metadataObjectStatus(ctx, req.Storage, entry)
 	if err != nil {
 		return nil, err
 	}
 
+	entry.Deleted = status == metadataStatusDeleted
+
+	violations, err := storeValidMetadataEntry(ctx, req.Storage, addr, entry, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(violations) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("metadata violates the %s metadata schema: %s", entry.Type(), strings.Join(violations, "; "))), nil
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"id": addr,
+			"id":      addr,
+			"version": entry.Version,
 		},
 	}, nil
 }
@@ -125,90 +371,419 @@ func (b *backend) pathMetadataRead(ctx context.Context, req *logical.Request, da
 		return logical.ErrorResponse(fmt.Sprintf("invalid 'lookup' value %q, only 'cluster' or 'database' is supporter", target)), nil
 	}
 
-	meta, err := parseMetaAttr(data)
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	var sel selector
+	if _, ok := data.GetOk("data"); ok {
+		meta, err := parseMetaAttr(data)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		sel = selectorFromMap(meta)
+	}
+
+	if raw, ok := data.GetOk("selector"); ok {
+		parsed, err := parseSelector(raw.(string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid selector: %s", err)), nil
+		}
+
+		sel = append(sel, parsed...)
 	}
 
-	addr := fmt.Sprintf("%s/", target)
+	if sel.Empty() {
+		return logical.ErrorResponse("'data' or 'selector' attribute is required to perform lookup"), nil
+	}
+
+	includeDeleted := data.Get("include_deleted").(bool)
 
-	objList, err := req.Storage.List(ctx, PathMeta.For(addr))
+	objects, err := listObjectMetadata(ctx, req.Storage, target)
 	if err != nil {
 		return nil, err
 	}
 
 	var matches []string
-	for _, key := range objList {
-		m, err := loadMetadataEntry(ctx, req.Storage, PathMeta.For(addr+key))
-		if err == ErrNotFound {
+	info := map[string]interface{}{}
+	for _, m := range objects {
+		if !sel.Matches(m.Data) || (m.Deleted && !includeDeleted) {
 			continue
 		}
 
+		status, err := metadataObjectStatus(ctx, req.Storage, m)
 		if err != nil {
 			return nil, err
 		}
 
-		if matchAttrs(m.Data, meta) {
-			matches = append(matches, m.Name())
+		// Objects without metadata are at version 0, like for check-and-set
+		version := 0
+		if len(m.Data) > 0 {
+			version = m.CurrentVersion()
+		}
+
+		matches = append(matches, m.Name())
+		info[m.Name()] = map[string]interface{}{
+			"id":       m.ID(),
+			"cluster":  m.Cluster,
+			"database": m.Database,
+			"metadata": m.Data,
+			"version":  version,
+			"status":   status,
 		}
 	}
 
-	return logical.ListResponse(matches), nil
+	return logical.ListResponseWithInfo(matches, info), nil
 }
 
-func matchAttrs(target, source map[string]string) bool {
-	for sk, sv := range source {
-		tv, ok := target[sk]
-		if !ok {
-			return false
-		}
+// metadataObjectStatus returns the status of the object that metadata is
+// attached to, 'missing' means the object has been purged.
+func metadataObjectStatus(ctx context.Context, storage logical.Storage, m *Metadata) (string, error) {
+	cluster, err := loadClusterEntry(ctx, storage, m.Cluster)
+	if err == ErrNotFound {
+		return metadataStatusMissing, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
 
-		if tv != sv {
-			return false
+	if m.Database == "" {
+		if cluster.IsDisabled() {
+			return metadataStatusDeleted, nil
 		}
+
+		return metadataStatusActive, nil
+	}
+
+	database, err := loadDbEntry(ctx, storage, m.Cluster, m.Database)
+	if err == ErrNotFound {
+		return metadataStatusMissing, nil
 	}
 
-	return true
+	if err != nil {
+		return "", err
+	}
+
+	if cluster.IsDisabled() || database.IsDisabled() {
+		return metadataStatusDeleted, nil
+	}
+
+	return metadataStatusActive, nil
 }
 
-func (b *backend) pathMetadataList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	matches := map[string]interface{}{}
-	clusters, err := req.Storage.List(ctx, PathMeta.For("cluster/"))
+// listClusterMetadata returns the IDs of the metadata of cluster
+// and of the databases in cluster
+func listClusterMetadata(ctx context.Context, storage logical.Storage, cluster string) ([]string, error) {
+	ids := []string{clusterMetadataID(cluster)}
+
+	databases, err := storage.List(ctx, PathMeta.For(databaseMetadataID(cluster, "")))
 	if err != nil {
 		return nil, err
 	}
 
-	for _, c := range clusters {
-		cid := "cluster/" + c
-		meta, err := loadMetadataEntry(ctx, req.Storage, PathMeta.For(cid))
+	for _, database := range databases {
+		ids = append(ids, databaseMetadataID(cluster, database))
+	}
+
+	return ids, nil
+}
+
+// markMetadataDeleted marks the metadata of a deleted database, or of a
+// deleted cluster and all its databases when database is empty.
+func (b *backend) markMetadataDeleted(ctx context.Context, storage logical.Storage, cluster, database string) error {
+	b.metadataLock.Lock()
+	defer b.metadataLock.Unlock()
+
+	ids := []string{databaseMetadataID(cluster, database)}
+	if database == "" {
+		var err error
+		ids, err = listClusterMetadata(ctx, storage, cluster)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		m, err := loadMetadataEntry(ctx, storage, PathMeta.For(id))
 		if err == ErrNotFound {
 			continue
 		}
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		matches[cid] = meta.Data
+		if m.Deleted {
+			continue
+		}
+
+		m.Deleted = true
+		m.Version = m.CurrentVersion() + 1
+		if err := storeMetadataEntry(ctx, storage, PathMeta.For(id), m); err != nil {
+			return err
+		}
 	}
 
-	databases, err := req.Storage.List(ctx, PathMeta.For("database/"))
+	return nil
+}
+
+// purgeMetadata deletes the metadata of a purged database, or of a purged
+// cluster and all its databases when database is empty.
+func (b *backend) purgeMetadata(ctx context.Context, storage logical.Storage, cluster, database string) error {
+	b.metadataLock.Lock()
+	defer b.metadataLock.Unlock()
+
+	ids := []string{databaseMetadataID(cluster, database)}
+	if database == "" {
+		var err error
+		ids, err = listClusterMetadata(ctx, storage, cluster)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		if err := storage.Delete(ctx, PathMeta.For(id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storeValidMetadataEntry stores the metadata if it satisfies the schema of
+// its object type, otherwise the violations are returned and nothing is
+// stored. The caller must hold the metadata lock.
+func storeValidMetadataEntry(ctx context.Context, storage logical.Storage, id string, m *Metadata, existing map[string]string) ([]string, error) {
+	schema, err := loadMetadataSchema(ctx, storage, m.Type())
 	if err != nil {
 		return nil, err
 	}
 
-	for _, d := range databases {
-		did := "database/" + d
-		meta, err := loadMetadataEntry(ctx, req.Storage, PathMeta.For(did))
+	if schema != nil {
+		if violations := schema.Violations(m.Data, existing); len(violations) > 0 {
+			return violations, nil
+		}
+	}
+
+	return nil, storeMetadataEntry(ctx, storage, PathMeta.For(id), m)
+}
+
+// cloneMetadata copies the metadata of the source cluster to the target, and
+// the metadata of every database that was cloned to the target. The overrides
+// are applied on top of the copied metadata. Metadata that violates the schema
+// is not copied and its violations are returned keyed by the target ID.
+func (b *backend) cloneMetadata(ctx context.Context, storage logical.Storage, source, target string, overrides map[string]string) (copied int, rejected map[string][]string, err error) {
+	b.metadataLock.Lock()
+	defer b.metadataLock.Unlock()
+
+	ids, err := listClusterMetadata(ctx, storage, source)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rejected = map[string][]string{}
+
+	for _, id := range ids {
+		m, err := loadMetadataEntry(ctx, storage, PathMeta.For(id))
 		if err == ErrNotFound {
 			continue
 		}
 
+		if err != nil {
+			return copied, rejected, err
+		}
+
+		targetID := clusterMetadataID(target)
+		if m.Database != "" {
+			db, err := loadDbEntry(ctx, storage, target, m.Database)
+			if err == ErrNotFound {
+				continue
+			}
+
+			if err != nil {
+				return copied, rejected, err
+			}
+
+			m.Deleted = db.IsDisabled()
+			targetID = databaseMetadataID(target, m.Database)
+		}
+
+		m.Cluster = target
+		m.Version = 1
+
+		var existing map[string]string
+		if current, err := loadMetadataEntry(ctx, storage, PathMeta.For(targetID)); err == nil {
+			m.Version = current.CurrentVersion() + 1
+			existing = current.Data
+		} else if err != ErrNotFound {
+			return copied, rejected, err
+		}
+
+		for k, v := range overrides {
+			m.Data[k] = v
+		}
+
+		violations, err := storeValidMetadataEntry(ctx, storage, targetID, m, existing)
+		if err != nil {
+			return copied, rejected, err
+		}
+
+		if len(violations) > 0 {
+			rejected[targetID] = violations
+			continue
+		}
+
+		copied++
+	}
+
+	return copied, rejected, nil
+}
+
+// checkMetadata returns the IDs of metadata attached to objects that do not
+// exist anymore, and of metadata whose deleted mark does not match the status
+// of its object. With repair the former are deleted and the latter are fixed.
+func (b *backend) checkMetadata(ctx context.Context, storage logical.Storage, repair bool) (missing, mismatched []string, err error) {
+	b.metadataLock.Lock()
+	defer b.metadataLock.Unlock()
+
+	for _, target := range []string{"cluster", "database"} {
+		ids, err := listMetadataIDs(ctx, storage, target)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, id := range ids {
+			m, err := loadMetadataEntry(ctx, storage, PathMeta.For(id))
+			if err == ErrNotFound {
+				continue
+			}
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			status, err := metadataObjectStatus(ctx, storage, m)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch {
+			case status == metadataStatusMissing:
+				missing = append(missing, id)
+				if repair {
+					err = storage.Delete(ctx, PathMeta.For(id))
+				}
+			case m.Deleted != (status == metadataStatusDeleted):
+				mismatched = append(mismatched, id)
+				if repair {
+					m.Deleted = status == metadataStatusDeleted
+					m.Version = m.CurrentVersion() + 1
+					err = storeMetadataEntry(ctx, storage, PathMeta.For(id), m)
+				}
+			}
+
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return missing, mismatched, nil
+}
+
+func (b *backend) pathMetadataCheckRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.metadataCheck(ctx, req.Storage, false)
+}
+
+func (b *backend) pathMetadataCheckUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.metadataCheck(ctx, req.Storage, true)
+}
+
+func (b *backend) metadataCheck(ctx context.Context, storage logical.Storage, repair bool) (*logical.Response, error) {
+	missing, mismatched, err := b.checkMetadata(ctx, storage, repair)
+	if err != nil {
+		return nil, err
+	}
+
+	violations := map[string][]string{}
+	for _, target := range []string{"cluster", "database"} {
+		schema, err := loadMetadataSchema(ctx, storage, target)
+		if err != nil {
+			return nil, err
+		}
+
+		if schema == nil {
+			continue
+		}
+
+		found, err := metadataSchemaViolations(ctx, storage, target, schema)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, v := range found {
+			violations[id] = v
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"missing":           missing,
+			"mismatched":        mismatched,
+			"schema_violations": violations,
+			"repaired":          repair,
+		},
+	}, nil
+}
+
+// matchDatabases returns the names of databases registered in the cluster
+// whose metadata is matched by the selector. Databases without metadata
+// are matched as if their metadata was empty.
+func matchDatabases(ctx context.Context, storage logical.Storage, cluster string, sel selector) ([]string, error) {
+	databases, err := storage.List(ctx, PathDatabase.For(cluster, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, database := range databases {
+		data := map[string]string{}
+		m, err := loadMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID(cluster, database)))
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+
+		if m != nil {
+			data = m.Data
+		}
+
+		if sel.Matches(data) {
+			matches = append(matches, database)
+		}
+	}
+
+	return matches, nil
+}
+
+func (b *backend) pathMetadataList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	matches := map[string]interface{}{}
+	for _, target := range []string{"cluster", "database"} {
+		ids, err := listMetadataIDs(ctx, req.Storage, target)
 		if err != nil {
 			return nil, err
 		}
 
-		matches[did] = meta.Data
+		for _, id := range ids {
+			meta, err := loadMetadataEntry(ctx, req.Storage, PathMeta.For(id))
+			if err == ErrNotFound {
+				continue
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			matches[id] = meta.Data
+		}
 	}
 
 	var keys []string
@@ -225,7 +800,36 @@ func (b *backend) pathMetadataDelete(ctx context.Context, req *logical.Request,
 		return logical.ErrorResponse("'id' is required to delete metadata"), nil
 	}
 
-	err := req.Storage.Delete(ctx, PathMeta.For(rid))
+	id, err := resolveMetadataID(ctx, req.Storage, rid.(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	b.metadataLock.Lock()
+	defer b.metadataLock.Unlock()
+
+	version := 0
+	current, err := loadMetadataEntry(ctx, req.Storage, PathMeta.For(id))
+	if err == nil {
+		version = current.CurrentVersion()
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+
+	if cas, ok := data.GetOk("cas"); ok && cas.(int) != version {
+		return logical.ErrorResponse(fmt.Sprintf("check-and-set parameter %d did not match the current version %d of the metadata", cas, version)), nil
+	}
+
+	// Deleting and writing the metadata again would change the immutable
+	// keys, so they are only removed with the object when it is purged
+	if current != nil {
+		resp, err := checkNoImmutableKeys(ctx, req.Storage, current)
+		if resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	err = req.Storage.Delete(ctx, PathMeta.For(id))
 	if err != nil {
 		return nil, err
 	}
@@ -233,6 +837,123 @@ func (b *backend) pathMetadataDelete(ctx context.Context, req *logical.Request,
 	return &logical.Response{}, nil
 }
 
+// checkNoImmutableKeys refuses the deletion of metadata that has immutable
+// keys while the object it is attached to is registered
+func checkNoImmutableKeys(ctx context.Context, storage logical.Storage, m *Metadata) (*logical.Response, error) {
+	schema, err := loadMetadataSchema(ctx, storage, m.Type())
+	if err != nil || schema == nil {
+		return nil, err
+	}
+
+	keys := schema.ImmutableKeys(m.Data)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	status, err := metadataObjectStatus(ctx, storage, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == metadataStatusMissing {
+		return nil, nil
+	}
+
+	return logical.ErrorResponse(fmt.Sprintf("metadata has immutable keys %s and is only deleted when the %s is purged", strings.Join(keys, ", "), m.Type())), nil
+}
+
+// resolveMetadataID returns the current ID of metadata for an ID in the legacy
+// database/<cluster>-<database> layout. Other IDs are returned unchanged.
+func resolveMetadataID(ctx context.Context, storage logical.Storage, id string) (string, error) {
+	name := strings.TrimPrefix(id, "database/")
+	if name == id || strings.Contains(name, "/") {
+		return id, nil
+	}
+
+	ids, err := listMetadataIDs(ctx, storage, "database")
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	for _, candidate := range ids {
+		if candidate == id {
+			return id, nil
+		}
+
+		m, err := loadMetadataEntry(ctx, storage, PathMeta.For(candidate))
+		if err == ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if legacyDatabaseMetadataID(m.Cluster, m.Database) == id {
+			matches = append(matches, candidate)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return id, nil
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("metadata ID %q is ambiguous, it matches %s", id, strings.Join(matches, ", "))
+	}
+}
+
+// migrateMetadataIDs moves the database metadata stored in the legacy
+// database/<cluster>-<database> layout to database/<cluster>/<database>.
+// Entries that already exist in the new layout are kept.
+func migrateMetadataIDs(ctx context.Context, storage logical.Storage) (int, error) {
+	keys, err := storage.List(ctx, PathMeta.For("database/"))
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		legacy := "database/" + key
+		m, err := loadMetadataEntry(ctx, storage, PathMeta.For(legacy))
+		if err == ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return migrated, err
+		}
+
+		if m.Cluster == "" || m.Database == "" {
+			continue
+		}
+
+		id := databaseMetadataID(m.Cluster, m.Database)
+		_, err = loadMetadataEntry(ctx, storage, PathMeta.For(id))
+		if err == ErrNotFound {
+			err = storeMetadataEntry(ctx, storage, PathMeta.For(id), m)
+		}
+
+		if err != nil {
+			return migrated, err
+		}
+
+		if err := storage.Delete(ctx, PathMeta.For(legacy)); err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
+
 func parseMetaAttr(data *framework.FieldData) (map[string]string, error) {
 	md, ok := data.GetOk("data")
 	if !ok {
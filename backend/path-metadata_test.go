@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"fmt"
 	logicaltest "github.com/hashicorp/vault/helper/testhelpers/logical"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -9,21 +10,6 @@ import (
 	"testing"
 )
 
-func TestMatchAttrs(t *testing.T) {
-	sm := map[string]string{
-		"key-one": "value-one",
-	}
-
-	tm := map[string]string{
-		"key-one": "value-one",
-		"key-two": "value-two",
-	}
-
-	if !matchAttrs(tm, sm) {
-		t.Fatalf("Expected %+v to match %+v", sm, tm)
-	}
-}
-
 func TestMetadata_basic(t *testing.T) {
 	backend := testGetBackend(t)
 	cleanup, attr := prepareTestContainer(t)
@@ -46,7 +32,7 @@ func TestMetadata_basic(t *testing.T) {
 
 	expectListKeys := []string{
 		"cluster/test-acc-cluster",
-		"database/test-acc-cluster-test-db",
+		"database/test-acc-cluster/test-db",
 	}
 
 	logicaltest.Test(t, logicaltest.TestCase{
@@ -172,3 +158,455 @@ func testAccDeleteMeta(t *testing.T, id string) logicaltest.TestStep {
 		ErrorOk:   false,
 	}
 }
+
+func TestMetadataLookup_selector(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	if err := storeClusterEntry(ctx, storage, testCluster, &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"}); err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	databases := []struct {
+		name     string
+		disabled bool
+		meta     map[string]string
+	}{
+		{"orders", false, map[string]string{"env": "prod", "team": "payments"}},
+		{"billing", false, map[string]string{"env": "staging", "team": "payments"}},
+		{"refunds", true, map[string]string{"env": "dev", "team": "payments"}},
+		{"search", false, map[string]string{"env": "dev", "team": "search"}},
+	}
+
+	for _, d := range databases {
+		db := &DbConfig{Cluster: testCluster, Database: d.name, ObjectsOwner: "owner"}
+		if d.disabled {
+			db.Disable()
+		}
+
+		if err := storeDbEntry(ctx, storage, testCluster, d.name, db); err != nil {
+			t.Fatalf("failed to store database. %s", err)
+		}
+
+		meta := &Metadata{Cluster: testCluster, Database: d.name, Data: d.meta}
+		if err := storeMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID(testCluster, d.name)), meta); err != nil {
+			t.Fatalf("failed to store metadata. %s", err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"type":     "database",
+			"selector": "env!=prod, team in (payments)",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to lookup metadata. err: %v, resp: %v", err, resp)
+	}
+
+	keys := resp.Data["keys"].([]string)
+	sort.Strings(keys)
+	expect := []string{testCluster + "/billing", testCluster + "/refunds"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("expected %+v to match %+v", expect, keys)
+	}
+
+	info := resp.Data["key_info"].(map[string]interface{})
+	for name, status := range map[string]string{expect[0]: "active", expect[1]: "deleted"} {
+		if got := info[name].(map[string]interface{})["status"]; got != status {
+			t.Errorf("expected %s to be %s, got %v", name, status, got)
+		}
+	}
+
+	// Objects without metadata are matched by negative requirements
+	if err := storeDbEntry(ctx, storage, testCluster, "archive", &DbConfig{Cluster: testCluster, Database: "archive", ObjectsOwner: "owner"}); err != nil {
+		t.Fatalf("failed to store database. %s", err)
+	}
+
+	for target, expect := range map[string][]string{
+		"database": {testCluster + "/archive"},
+		"cluster":  {testCluster},
+	} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metadata",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"type":     target,
+				"selector": "!team",
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to lookup metadata. err: %v, resp: %v", err, resp)
+		}
+
+		if keys := resp.Data["keys"].([]string); !reflect.DeepEqual(expect, keys) {
+			t.Fatalf("%s: expected %+v to match %+v", target, expect, keys)
+		}
+	}
+
+	sel, err := parseSelector("!team")
+	if err != nil {
+		t.Fatalf("failed to parse selector. %s", err)
+	}
+
+	matched, err := matchDatabases(ctx, storage, testCluster, sel)
+	if err != nil || !reflect.DeepEqual([]string{"archive"}, matched) {
+		t.Fatalf("expected database without metadata to match, got %+v. err: %v", matched, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "metadata",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"type":     "database",
+			"selector": "env in (prod",
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected invalid selector to be rejected. err: %v, resp: %v", err, resp)
+	}
+}
+
+func TestMetadataLifecycle(t *testing.T) {
+	b := testGetBackend(t).(*backend)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	if err := storeClusterEntry(ctx, storage, testCluster, &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"}); err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	for _, name := range []string{"orders", "billing"} {
+		if err := storeDbEntry(ctx, storage, testCluster, name, &DbConfig{Cluster: testCluster, Database: name}); err != nil {
+			t.Fatalf("failed to store database. %s", err)
+		}
+
+		meta := &Metadata{Cluster: testCluster, Database: name, Data: map[string]string{"team": "payments"}}
+		if err := storeMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID(testCluster, name)), meta); err != nil {
+			t.Fatalf("failed to store metadata. %s", err)
+		}
+	}
+
+	meta := &Metadata{Cluster: testCluster, Data: map[string]string{"env": "prod"}}
+	if err := storeMetadataEntry(ctx, storage, PathMeta.For(clusterMetadataID(testCluster)), meta); err != nil {
+		t.Fatalf("failed to store metadata. %s", err)
+	}
+
+	// Clone copies the metadata of cluster and the inherited databases
+	if err := storeClusterEntry(ctx, storage, "clone", &ClusterConfig{Host: "clone", Port: 5432, Database: "postgres"}); err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	if err := storeDbEntry(ctx, storage, "clone", "orders", &DbConfig{Cluster: "clone", Database: "orders"}); err != nil {
+		t.Fatalf("failed to store database. %s", err)
+	}
+
+	copied, rejected, err := b.cloneMetadata(ctx, storage, testCluster, "clone", map[string]string{"env": "staging"})
+	if err != nil || copied != 2 || len(rejected) != 0 {
+		t.Fatalf("expected metadata of 2 objects to be copied, got %d %v. err: %v", copied, rejected, err)
+	}
+
+	clone, err := loadMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID("clone", "orders")))
+	if err != nil {
+		t.Fatalf("failed to load cloned metadata. %s", err)
+	}
+
+	if clone.Cluster != "clone" || clone.Data["team"] != "payments" || clone.Data["env"] != "staging" {
+		t.Fatalf("unexpected cloned metadata %+v", clone)
+	}
+
+	// Overrides are validated against the schema like any other write
+	schema := &MetadataSchema{
+		AllowUnknownKeys: true,
+		Keys:             map[string]MetadataKeySchema{"env": {AllowedValues: []string{"prod", "staging"}}},
+	}
+	if err := storeMetadataSchema(ctx, storage, "database", schema); err != nil {
+		t.Fatalf("failed to store schema. %s", err)
+	}
+
+	copied, rejected, err = b.cloneMetadata(ctx, storage, testCluster, "clone", map[string]string{"env": "dev"})
+	if err != nil || copied != 1 {
+		t.Fatalf("expected only the cluster metadata to be copied, got %d. err: %v", copied, err)
+	}
+
+	if _, ok := rejected[databaseMetadataID("clone", "orders")]; !ok || len(rejected) != 1 {
+		t.Fatalf("expected database metadata to be rejected, got %v", rejected)
+	}
+
+	clone, err = loadMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID("clone", "orders")))
+	if err != nil || clone.Data["env"] != "staging" {
+		t.Fatalf("expected rejected metadata to be unchanged, got %+v. err: %v", clone, err)
+	}
+
+	if err := storage.Delete(ctx, PathMetaSchema.For("database")); err != nil {
+		t.Fatalf("failed to delete schema. %s", err)
+	}
+
+	// Deleting the database marks its metadata so lookups skip it
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "cluster/" + testCluster + "/billing",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete database. err: %v, resp: %v", err, resp)
+	}
+
+	for includeDeleted, expect := range map[bool][]string{
+		false: {testCluster + "/orders"},
+		true:  {testCluster + "/billing", testCluster + "/orders"},
+	} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "metadata",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"type":            "database",
+				"selector":        "team=payments, !env",
+				"include_deleted": includeDeleted,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to lookup metadata. err: %v, resp: %v", err, resp)
+		}
+
+		keys := resp.Data["keys"].([]string)
+		sort.Strings(keys)
+		if !reflect.DeepEqual(expect, keys) {
+			t.Fatalf("include_deleted=%t: expected %+v to match %+v", includeDeleted, expect, keys)
+		}
+	}
+
+	// Purging the database deletes its metadata
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "gc/cluster/" + testCluster + "/billing",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to purge database. err: %v, resp: %v", err, resp)
+	}
+
+	if _, err := loadMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID(testCluster, "billing"))); err != ErrNotFound {
+		t.Fatalf("expected metadata of purged database to be deleted, got %v", err)
+	}
+
+	// Metadata left behind by purges before it was cascaded is reported
+	orphan := &Metadata{Cluster: testCluster, Database: "refunds", Data: map[string]string{"team": "payments"}}
+	if err := storeMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID(testCluster, "refunds")), orphan); err != nil {
+		t.Fatalf("failed to store metadata. %s", err)
+	}
+
+	for _, op := range []logical.Operation{logical.ReadOperation, logical.UpdateOperation} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      "metadata/check",
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to check metadata. err: %v, resp: %v", err, resp)
+		}
+
+		expect := []string{databaseMetadataID(testCluster, "refunds")}
+		if !reflect.DeepEqual(expect, resp.Data["missing"]) {
+			t.Fatalf("expected %+v to match %+v", expect, resp.Data["missing"])
+		}
+	}
+
+	if _, err := loadMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID(testCluster, "refunds"))); err != ErrNotFound {
+		t.Fatalf("expected missing metadata to be repaired, got %v", err)
+	}
+}
+
+func TestMetadataMigration(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	legacy := &Metadata{Cluster: "a-b", Database: "c", Data: map[string]string{"env": "prod"}}
+	if err := storeMetadataEntry(ctx, storage, PathMeta.For(legacyDatabaseMetadataID("a-b", "c")), legacy); err != nil {
+		t.Fatalf("failed to store metadata. %s", err)
+	}
+
+	err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage})
+	if err != nil {
+		t.Fatalf("failed to initialize backend. %s", err)
+	}
+
+	m, err := loadMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID("a-b", "c")))
+	if err != nil || m.Data["env"] != "prod" {
+		t.Fatalf("expected metadata to be migrated, got %+v. err: %v", m, err)
+	}
+
+	if _, err := loadMetadataEntry(ctx, storage, PathMeta.For("database/a-b-c")); err != ErrNotFound {
+		t.Fatalf("expected legacy entry to be removed, got %v", err)
+	}
+
+	// Both databases have the same legacy ID
+	other := &Metadata{Cluster: "a", Database: "b-c", Data: map[string]string{"env": "dev"}}
+	if err := storeMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID("a", "b-c")), other); err != nil {
+		t.Fatalf("failed to store metadata. %s", err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/database/a-b-c",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected ambiguous legacy ID to be rejected. err: %v, resp: %v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/" + databaseMetadataID("a", "b-c"),
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete metadata. err: %v, resp: %v", err, resp)
+	}
+
+	// The legacy ID resolves once it is unambiguous
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "metadata/database/a-b-c",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete metadata. err: %v, resp: %v", err, resp)
+	}
+
+	if _, err := loadMetadataEntry(ctx, storage, PathMeta.For(databaseMetadataID("a-b", "c"))); err != ErrNotFound {
+		t.Fatalf("expected metadata to be deleted using legacy ID, got %v", err)
+	}
+}
+
+func TestMetadataUpdate_patch(t *testing.T) {
+	b := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	if err := storeClusterEntry(ctx, storage, testCluster, &ClusterConfig{Host: "localhost", Port: 5432, Database: "postgres"}); err != nil {
+		t.Fatalf("failed to store cluster. %s", err)
+	}
+
+	steps := []struct {
+		data    map[string]interface{}
+		valid   bool
+		expect  map[string]string
+		version int
+	}{
+		{
+			data:    map[string]interface{}{"data": []string{"env=prod", "team=payments"}, "cas": 0},
+			valid:   true,
+			expect:  map[string]string{"env": "prod", "team": "payments"},
+			version: 1,
+		},
+		{
+			data: map[string]interface{}{"data": []string{"env=staging"}, "cas": 0},
+		},
+		{
+			data:    map[string]interface{}{"mode": "patch", "data": []string{"owner=alice"}, "cas": 1},
+			valid:   true,
+			expect:  map[string]string{"env": "prod", "team": "payments", "owner": "alice"},
+			version: 2,
+		},
+		{
+			data: map[string]interface{}{"mode": "patch", "data": []string{"owner=bob"}, "cas": 1},
+		},
+		{
+			data:    map[string]interface{}{"mode": "patch", "remove_keys": "team,missing"},
+			valid:   true,
+			expect:  map[string]string{"env": "prod", "owner": "alice"},
+			version: 3,
+		},
+		{
+			data: map[string]interface{}{"data": []string{"env=dev"}, "remove_keys": "owner"},
+		},
+		{
+			data: map[string]interface{}{"mode": "patch", "remove_keys": "env,owner"},
+		},
+		{
+			data: map[string]interface{}{"mode": "merge", "data": []string{"env=dev"}},
+		},
+		{
+			data:    map[string]interface{}{"data": []string{"env=dev"}},
+			valid:   true,
+			expect:  map[string]string{"env": "dev"},
+			version: 4,
+		},
+	}
+
+	for i, step := range steps {
+		step.data["cluster"] = testCluster
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "metadata",
+			Storage:   storage,
+			Data:      step.data,
+		})
+		if err != nil {
+			t.Fatalf("step %d: failed to write metadata. %s", i, err)
+		}
+
+		if step.valid == (resp != nil && resp.IsError()) {
+			t.Fatalf("step %d: expected valid=%t, got %+v", i, step.valid, resp)
+		}
+
+		m, err := loadMetadataEntry(ctx, storage, PathMeta.For(clusterMetadataID(testCluster)))
+		if err != nil {
+			t.Fatalf("step %d: failed to load metadata. %s", i, err)
+		}
+
+		if !step.valid {
+			continue
+		}
+
+		if resp.Data["version"] != step.version || m.Version != step.version {
+			t.Fatalf("step %d: expected version %d, got %v and %d", i, step.version, resp.Data["version"], m.Version)
+		}
+
+		if !reflect.DeepEqual(step.expect, m.Data) {
+			t.Fatalf("step %d: expected %+v to match %+v", i, step.expect, m.Data)
+		}
+	}
+
+	// Marking the metadata deleted with its cluster is an update as well
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "cluster/" + testCluster,
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete cluster. err: %v, resp: %v", err, resp)
+	}
+
+	for _, step := range []struct {
+		cas   int
+		valid bool
+	}{{cas: 4}, {cas: 5, valid: true}} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "metadata/" + clusterMetadataID(testCluster),
+			Storage:   storage,
+			Data:      map[string]interface{}{"cas": step.cas},
+		})
+		if err != nil {
+			t.Fatalf("cas %d: failed to delete metadata. %s", step.cas, err)
+		}
+
+		if step.valid == (resp != nil && resp.IsError()) {
+			t.Fatalf("cas %d: expected valid=%t, got %+v", step.cas, step.valid, resp)
+		}
+	}
+
+	if _, err := loadMetadataEntry(ctx, storage, PathMeta.For(clusterMetadataID(testCluster))); err != ErrNotFound {
+		t.Fatalf("expected metadata to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/dbtxn"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+const (
+	dryRunModeRollback = "rollback"
+	dryRunModeCommit   = "commit"
+)
+
+type dryRunStep struct {
+	Stage     string
+	Statement string
+	Duration  time.Duration
+	Err       error
+}
+
+func (s *dryRunStep) AsMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"stage":       s.Stage,
+		"statement":   s.Statement,
+		"duration_ms": s.Duration.Milliseconds(),
+		"error":       "",
+	}
+
+	if s.Err != nil {
+		m["error"] = s.Err.Error()
+	}
+
+	return m
+}
+
+type dryRun struct {
+	steps []*dryRunStep
+}
+
+func (d *dryRun) record(stage, statement string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	d.steps = append(d.steps, &dryRunStep{
+		Stage:     stage,
+		Statement: statement,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+
+	return err
+}
+
+// execute runs the statements in transaction and stops at the first failure
+// because postgres aborts the transaction on error anyway.
+func (d *dryRun) execute(ctx context.Context, tx *sql.Tx, stage string, statements []string, m map[string]string) error {
+	for _, query := range statements {
+		query = strings.TrimSpace(query)
+		if len(query) == 0 {
+			continue
+		}
+
+		err := d.record(stage, query, func() error {
+			return dbtxn.ExecuteTxQuery(ctx, tx, m, query)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *dryRun) failed() bool {
+	for _, s := range d.steps {
+		if s.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *backend) pathRoleDryRun(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	clusterName, err := resolveClusterName(ctx, req.Storage, data.Get("cluster").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	databaseName := data.Get("database").(string)
+
+	mode := data.Get("mode").(string)
+	switch mode {
+	case dryRunModeRollback, dryRunModeCommit:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("Invalid mode %q, valid options are 'rollback' or 'commit'", mode)), nil
+	}
+
+	role, err := loadRoleEntry(ctx, req.Storage, roleName)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s is not configured", roleName)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if role.IsClusterScoped() {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s spans multiple databases, testing is only supported for roles scoped to a single database", roleName)), nil
+	}
+
+	cluster, err := loadClusterEntry(ctx, req.Storage, clusterName)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is not configured", clusterName)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster.IsDisabled() {
+		return logical.ErrorResponse(fmt.Sprintf("Cluster %s is marked as deleted", clusterName)), nil
+	}
+
+	database, err := loadDbEntry(ctx, req.Storage, clusterName, databaseName)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Database %s is not configured", databaseName)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if database.IsDisabled() {
+		return logical.ErrorResponse(fmt.Sprintf("Database %s is marked as deleted", databaseName)), nil
+	}
+
+	userUUID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	username := fmt.Sprintf("v-test-%s", userUUID)
+	if len(username) > 63 {
+		username = username[:63]
+	}
+
+	password, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]string{
+		"user":          pq.QuoteIdentifier(username),
+		"password":      password,
+		"expiration":    formatExpiration(time.Now().Add(5 * time.Minute)),
+		"database":      pq.QuoteIdentifier(databaseName),
+		"objects_owner": pq.QuoteIdentifier(database.ObjectsOwner),
+		"group":         pq.QuoteIdentifier(cluster.ManagementRole),
+	}
+
+	resp, err := b.checkRootExecution(ctx, req, roleName, role)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	db, err := b.getConn(ctx, req.Storage, role.GetConnType(), clusterName, databaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	run := &dryRun{}
+	resp = &logical.Response{}
+
+	if mode == dryRunModeRollback {
+		err = run.inTransaction(ctx, db, role, databaseName, username, database.ObjectsOwner, m)
+	} else {
+		err = run.withCommit(ctx, db, cluster, role, databaseName, username, password, m)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == dryRunModeCommit && run.failed() {
+		resp.AddWarning(fmt.Sprintf("Dry run did not complete successfully, user %s may have to be removed manually", username))
+	}
+
+	steps := make([]map[string]interface{}, 0, len(run.steps))
+	for _, s := range run.steps {
+		steps = append(steps, s.AsMap())
+	}
+
+	resp.Data = map[string]interface{}{
+		"username": username,
+		"mode":     mode,
+		"success":  !run.failed(),
+		"steps":    steps,
+	}
+
+	return resp, nil
+}
+
+// inTransaction runs all statements in a single transaction that is always
+// rolled back. The connection check only verifies that the user can login and
+// has the connect privilege on the database because the user is not visible
+// to other sessions.
+func (d *dryRun) inTransaction(ctx context.Context, db *sql.DB, role *RoleConfig, databaseName, username, objectsOwner string, m map[string]string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err = protectPassword(ctx, tx, m); err != nil {
+		return err
+	}
+
+	if err = d.execute(ctx, tx, "creation", role.GetCreationStatement(), m); err != nil {
+		return nil
+	}
+
+	err = d.record("connection", queryUserCanLogin, func() error {
+		return checkCanLogin(ctx, tx, username)
+	})
+
+	if err != nil {
+		return nil
+	}
+
+	err = d.record("connection", queryUserCanConnect, func() error {
+		return checkCanConnect(ctx, tx, username, databaseName)
+	})
+
+	if err != nil {
+		return nil
+	}
+
+	if role.AssumeObjectsOwner {
+		err = d.record("connection", queryUserHasRole, func() error {
+			return checkCanAssumeRole(ctx, tx, username, objectsOwner)
+		})
+
+		if err != nil {
+			return nil
+		}
+	}
+
+	_ = d.execute(ctx, tx, "revocation", role.RevocationStatement, m)
+	return nil
+}
+
+// withCommit creates the user, connects to the database as the new user
+// and drops it again. It is required when the statements can not run in
+// a transaction or when the connection has to be verified for real.
+func (d *dryRun) withCommit(ctx context.Context, db *sql.DB, cluster *ClusterConfig, role *RoleConfig, databaseName, username, password string, m map[string]string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = protectPassword(ctx, tx, m); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err = d.execute(ctx, tx, "creation", role.GetCreationStatement(), m); err != nil {
+		_ = tx.Rollback()
+		return nil
+	}
+
+	err = d.record("creation", "commit", tx.Commit)
+	if err != nil {
+		return nil
+	}
+
+	// Users of client certificate roles have no password to connect with
+	if !role.IsClientCertificate() {
+		_ = d.record("connection", "connect", func() error {
+			conn, err := sql.Open("postgres", cluster.dsnForUser(username, password, databaseName))
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			return conn.PingContext(ctx)
+		})
+	}
+
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = d.execute(ctx, tx, "revocation", role.RevocationStatement, m); err != nil {
+		_ = tx.Rollback()
+		return nil
+	}
+
+	_ = d.record("revocation", "commit", tx.Commit)
+	return nil
+}
+
+func checkCanConnect(ctx context.Context, tx *sql.Tx, username, databaseName string) error {
+	var canConnect bool
+	err := tx.QueryRowContext(ctx, queryUserCanConnect, username, databaseName).Scan(&canConnect)
+	if err != nil {
+		return err
+	}
+
+	if !canConnect {
+		return fmt.Errorf("user %s does not have the connect privilege on database %s", username, databaseName)
+	}
+
+	return nil
+}
+
+func checkCanLogin(ctx context.Context, tx *sql.Tx, username string) error {
+	var canLogin bool
+	err := tx.QueryRowContext(ctx, queryUserCanLogin, username).Scan(&canLogin)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("user %s was not created by creation statements", username)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !canLogin {
+		return fmt.Errorf("user %s is not allowed to login", username)
+	}
+
+	return nil
+}
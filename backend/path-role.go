@@ -4,7 +4,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,8 +18,30 @@ type RoleConfig struct {
 	DefaultTTL          int      `json:"default_ttl" mapstructure:"default_ttl"`
 	CreationStatement   []string `json:"creation_statement" mapstructure:"creation_statement"`
 	RevocationStatement []string `json:"revocation_statement" mapstructure:"revocation_statement"`
+	RenewStatement      []string `json:"renew_statement" mapstructure:"renew_statement"`
+	ExecuteAs           string   `json:"execute_as" mapstructure:"execute_as"`
+	AllowedGroups       []string `json:"allowed_groups" mapstructure:"allowed_groups"`
+	AssumeObjectsOwner  bool     `json:"assume_objects_owner" mapstructure:"assume_objects_owner"`
+	UserType            string   `json:"user_type" mapstructure:"user_type"`
+	CredentialType      string   `json:"credential_type" mapstructure:"credential_type"`
+	RevocationMode      string   `json:"revocation_mode" mapstructure:"revocation_mode"`
+	RevocationRetention int      `json:"revocation_retention" mapstructure:"revocation_retention"`
+	LoginStatement      []string `json:"login_statement" mapstructure:"login_statement"`
+	Version             int      `json:"version" mapstructure:"version"`
+
+	// Roles that span multiple databases in a cluster
+	Databases                   []string `json:"databases" mapstructure:"databases"`
+	DatabaseSelector            string   `json:"database_selector" mapstructure:"database_selector"`
+	DatabaseCreationStatement   []string `json:"database_creation_statement" mapstructure:"database_creation_statement"`
+	DatabaseRevocationStatement []string `json:"database_revocation_statement" mapstructure:"database_revocation_statement"`
+
+	// Privileges are compiled into the statements of role
+	Privileges []RolePrivilege `json:"privileges" mapstructure:"privileges"`
 }
 
+// maxRoleVersions is the number of role revisions retained in storage
+const maxRoleVersions = 10
+
 func (r *RoleConfig) GetDefaultTTL() time.Duration {
 	return time.Duration(r.DefaultTTL) * time.Second
 }
@@ -23,47 +50,677 @@ func (r *RoleConfig) GetMaxTTL() time.Duration {
 	return time.Duration(r.MaxTTL) * time.Second
 }
 
+// GetRenewStatement returns the statements to renew the user. Roles that
+// were configured before renew statements were supported use the default.
+func (r *RoleConfig) GetRenewStatement() []string {
+	if len(r.RenewStatement) == 0 {
+		return defaultRenewSQL
+	}
+
+	return r.RenewStatement
+}
+
+// GetCreationStatement returns the statements to create the user in a database.
+// When the role assumes the objects owner the user is configured to set the
+// objects owner as its role on login.
+func (r *RoleConfig) GetCreationStatement() []string {
+	if r.IsClusterScoped() || !r.AssumeObjectsOwner {
+		return r.CreationStatement
+	}
+
+	return append(append([]string{}, r.CreationStatement...), queryAssumeObjectsOwner)
+}
+
+// GetDatabaseCreationStatement returns the statements to grant access in each
+// database of a role that spans multiple databases.
+func (r *RoleConfig) GetDatabaseCreationStatement() []string {
+	if !r.AssumeObjectsOwner {
+		return r.DatabaseCreationStatement
+	}
+
+	return append(append([]string{}, r.DatabaseCreationStatement...), queryAssumeObjectsOwner)
+}
+
+// GetUserType returns the type of users created by the role. Roles that
+// were written before it was configurable create dynamic users.
+func (r *RoleConfig) GetUserType() string {
+	if r.UserType == "" {
+		return userTypeDynamic
+	}
+
+	return r.UserType
+}
+
+// GetCredentialType returns the type of credentials issued by the role.
+// Roles that were written before it was configurable issue passwords.
+func (r *RoleConfig) GetCredentialType() string {
+	if r.CredentialType == "" {
+		return credentialTypePassword
+	}
+
+	return r.CredentialType
+}
+
+// IsClientCertificate returns true if the role issues client certificates
+func (r *RoleConfig) IsClientCertificate() bool {
+	return r.GetCredentialType() == credentialTypeClientCert
+}
+
+// GetRevocationMode returns how the users are revoked. Roles that were
+// written before it was configurable drop the users.
+func (r *RoleConfig) GetRevocationMode() string {
+	if r.RevocationMode == "" {
+		return revocationModeDrop
+	}
+
+	return r.RevocationMode
+}
+
+func (r *RoleConfig) GetRevocationRetention() time.Duration {
+	return time.Duration(r.RevocationRetention) * time.Second
+}
+
+// IsEntityUser returns true if the role maps every Vault entity to a persistent user
+func (r *RoleConfig) IsEntityUser() bool {
+	return r.GetUserType() == userTypeEntity
+}
+
+// GetConnType returns the connection used to execute the role statements.
+// Roles that were written before it was configurable use management.
+func (r *RoleConfig) GetConnType() connType {
+	t, err := parseConnType(r.ExecuteAs)
+	if err != nil {
+		return connTypeMgmt
+	}
+
+	return t
+}
+
+// IsClusterScoped returns true if the credentials issued from
+// the role can connect with multiple databases in a cluster
+func (r *RoleConfig) IsClusterScoped() bool {
+	return len(r.Databases) > 0 || strings.TrimSpace(r.DatabaseSelector) != ""
+}
+
 func (r *RoleConfig) AsMap() map[string]interface{} {
 	return map[string]interface{}{
-		"max_ttl":              r.MaxTTL,
-		"default_ttl":          r.DefaultTTL,
-		"creation_statement":   r.CreationStatement,
-		"revocation_statement": r.RevocationStatement,
+		"max_ttl":                       r.MaxTTL,
+		"default_ttl":                   r.DefaultTTL,
+		"creation_statement":            r.CreationStatement,
+		"revocation_statement":          r.RevocationStatement,
+		"renew_statement":               r.GetRenewStatement(),
+		"execute_as":                    r.GetConnType().String(),
+		"allowed_groups":                r.AllowedGroups,
+		"assume_objects_owner":          r.AssumeObjectsOwner,
+		"user_type":                     r.GetUserType(),
+		"credential_type":               r.GetCredentialType(),
+		"revocation_mode":               r.GetRevocationMode(),
+		"revocation_retention":          r.RevocationRetention,
+		"login_statement":               r.LoginStatement,
+		"version":                       r.Version,
+		"databases":                     r.Databases,
+		"database_selector":             r.DatabaseSelector,
+		"database_creation_statement":   r.DatabaseCreationStatement,
+		"database_revocation_statement": r.DatabaseRevocationStatement,
+		"privileges":                    r.privilegesAsMap(),
 	}
 }
 
-func (r *RoleConfig) loadFromFields(data *framework.FieldData) error {
+func (r *RoleConfig) privilegesAsMap() []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(r.Privileges))
+	for _, p := range r.Privileges {
+		result = append(result, p.AsMap())
+	}
+
+	return result
+}
+
+// loadFromFields updates the role using the fields supplied in request.
+// When a new role is created the missing fields are set to their default
+// values, otherwise they are left untouched.
+func (r *RoleConfig) loadFromFields(data *framework.FieldData, create bool) error {
+	wasClusterScoped := !create && r.IsClusterScoped()
+
 	for k := range data.Schema {
+		v, ok := data.GetOk(k)
+		if !ok && !create {
+			continue
+		}
+
+		if !ok {
+			v = data.Get(k)
+		}
+
 		switch k {
 		case "max_ttl":
-			r.MaxTTL = data.Get(k).(int)
+			r.MaxTTL = v.(int)
 		case "default_ttl":
-			r.DefaultTTL = data.Get(k).(int)
+			r.DefaultTTL = v.(int)
 		case "creation_statement":
-			r.CreationStatement = data.Get(k).([]string)
+			r.CreationStatement = v.([]string)
 		case "revocation_statement":
-			r.RevocationStatement = data.Get(k).([]string)
+			r.RevocationStatement = v.([]string)
+		case "renew_statement":
+			r.RenewStatement = v.([]string)
+		case "execute_as":
+			r.ExecuteAs = v.(string)
+		case "revocation_mode":
+			r.RevocationMode = v.(string)
+		case "revocation_retention":
+			r.RevocationRetention = v.(int)
+		case "user_type":
+			r.UserType = v.(string)
+		case "credential_type":
+			r.CredentialType = v.(string)
+		case "login_statement":
+			r.LoginStatement = v.([]string)
+		case "assume_objects_owner":
+			r.AssumeObjectsOwner = v.(bool)
+		case "allowed_groups":
+			r.AllowedGroups = strutil.RemoveDuplicates(v.([]string), false)
+		case "databases":
+			r.Databases = v.([]string)
+		case "database_selector":
+			r.DatabaseSelector = v.(string)
+		case "database_creation_statement":
+			r.DatabaseCreationStatement = v.([]string)
+		case "database_revocation_statement":
+			r.DatabaseRevocationStatement = v.([]string)
+		case "privileges":
+			privileges, err := parsePrivileges(v.([]interface{}))
+			if err != nil {
+				return err
+			}
+			r.Privileges = privileges
+		}
+	}
+
+	// Statements of a role that spans multiple databases are executed in
+	// the maintenance database and cannot use the database specific defaults.
+	// The defaults of the new scope are also applied when an update changes
+	// the role between a single database and multiple databases.
+	scopeChanged := wasClusterScoped != r.IsClusterScoped()
+	if create || scopeChanged {
+		defaults := []struct {
+			field  string
+			target *[]string
+			value  []string
+		}{
+			{"creation_statement", &r.CreationStatement, defaultClusterCreationSQL},
+			{"revocation_statement", &r.RevocationStatement, defaultClusterRevocationSQL},
+			{"database_creation_statement", &r.DatabaseCreationStatement, defaultDatabaseCreationSQL},
+			{"database_revocation_statement", &r.DatabaseRevocationStatement, defaultDatabaseRevocationSQL},
+		}
+
+		for _, d := range defaults {
+			if _, ok := data.GetOk(d.field); ok {
+				continue
+			}
+
+			if r.IsClusterScoped() {
+				*d.target = d.value
+			} else if !create {
+				*d.target = data.Get(d.field).([]string)
+			}
+		}
+	}
+
+	// Users that authenticate with certificates are created without a password
+	if (create || scopeChanged) && r.IsClientCertificate() {
+		if _, ok := data.GetOk("creation_statement"); !ok {
+			r.CreationStatement = defaultClientCertCreationSQL
+		}
+	}
+
+	// Entity users are disabled instead of dropped on revocation
+	if create && r.IsEntityUser() {
+		if _, ok := data.GetOk("revocation_statement"); !ok {
+			r.RevocationStatement = defaultEntityRevocationSQL
+		}
+
+		if _, ok := data.GetOk("login_statement"); !ok {
+			r.LoginStatement = defaultEntityLoginSQL
+		}
+	}
+
+	if len(r.Privileges) > 0 {
+		for _, f := range []string{"creation_statement", "revocation_statement", "database_creation_statement", "database_revocation_statement"} {
+			if _, ok := data.GetOk(f); ok {
+				return fmt.Errorf("%s cannot be set together with privileges", f)
+			}
+		}
+
+		r.applyPrivileges()
+	}
+
+	return r.validate()
+}
+
+func (r *RoleConfig) validate() error {
+	if r.DefaultTTL < 0 {
+		return fmt.Errorf("Invalid default_ttl %d", r.DefaultTTL)
+	}
+
+	if r.MaxTTL < 0 {
+		return fmt.Errorf("Invalid max_ttl %d", r.MaxTTL)
+	}
+
+	if r.MaxTTL > 0 && r.DefaultTTL > r.MaxTTL {
+		return fmt.Errorf("default_ttl %d cannot be greater than max_ttl %d", r.DefaultTTL, r.MaxTTL)
+	}
+
+	if r.ExecuteAs != "" {
+		if _, err := parseConnType(r.ExecuteAs); err != nil {
+			return fmt.Errorf("Invalid execute_as %q, valid options are 'management' or 'root'", r.ExecuteAs)
+		}
+	}
+
+	for idx, p := range r.Privileges {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("invalid privileges [%d]: %s", idx, err)
+		}
+	}
+
+	switch r.GetUserType() {
+	case userTypeDynamic:
+	case userTypeEntity:
+		if r.IsClusterScoped() {
+			return fmt.Errorf("user_type 'entity' cannot be used with roles that span multiple databases")
+		}
+
+		if len(r.Privileges) > 0 {
+			return fmt.Errorf("user_type 'entity' cannot be used with privileges")
+		}
+
+		if isEmptyStatement(r.LoginStatement) {
+			return fmt.Errorf("login_statement must contain at least one statement")
+		}
+	default:
+		return fmt.Errorf("Invalid user_type %q, valid options are 'dynamic' or 'entity'", r.UserType)
+	}
+
+	switch r.GetCredentialType() {
+	case credentialTypePassword:
+	case credentialTypeClientCert:
+		if r.IsClusterScoped() {
+			return fmt.Errorf("credential_type 'client_certificate' cannot be used with roles that span multiple databases")
+		}
+
+		if r.IsEntityUser() {
+			return fmt.Errorf("credential_type 'client_certificate' cannot be used with user_type 'entity'")
+		}
+	default:
+		return fmt.Errorf("Invalid credential_type %q, valid options are 'password' or 'client_certificate'", r.CredentialType)
+	}
+
+	switch r.GetRevocationMode() {
+	case revocationModeDrop, revocationModeNologin:
+	case revocationModeNologinThenDrop:
+		if r.RevocationRetention <= 0 {
+			return fmt.Errorf("revocation_retention must be set when revocation_mode is %q", revocationModeNologinThenDrop)
+		}
+	default:
+		return fmt.Errorf("Invalid revocation_mode %q, valid options are 'drop', 'nologin' or 'nologin_then_drop_after'", r.RevocationMode)
+	}
+
+	if r.RevocationRetention < 0 {
+		return fmt.Errorf("Invalid revocation_retention %d", r.RevocationRetention)
+	}
+
+	if r.IsEntityUser() && r.GetRevocationMode() != revocationModeDrop {
+		return fmt.Errorf("revocation_mode of entity users must be 'drop', the revocation statements of these roles disable the user")
+	}
+
+	if r.AssumeObjectsOwner && len(r.Privileges) > 0 {
+		return fmt.Errorf("assume_objects_owner cannot be used with privileges, the users are not members of the objects owner")
+	}
+
+	if isEmptyStatement(r.CreationStatement) {
+		return fmt.Errorf("creation_statement must contain at least one statement")
+	}
+
+	if isEmptyStatement(r.RevocationStatement) {
+		return fmt.Errorf("revocation_statement must contain at least one statement")
+	}
+
+	type check struct {
+		name       string
+		statements []string
+		vars       []string
+	}
+
+	checks := []check{
+		{"creation_statement", r.CreationStatement, creationTemplateVars},
+		{"revocation_statement", r.RevocationStatement, revocationTemplateVars},
+		{"renew_statement", r.RenewStatement, renewTemplateVars},
+		{"login_statement", r.LoginStatement, creationTemplateVars},
+	}
+
+	if r.IsClientCertificate() {
+		checks[0].vars = clientCertCreationTemplateVars
+	}
+
+	if r.IsClusterScoped() {
+		if _, err := parseSelector(r.DatabaseSelector); err != nil {
+			return fmt.Errorf("invalid database_selector: %s", err)
+		}
+
+		if isEmptyStatement(r.DatabaseCreationStatement) {
+			return fmt.Errorf("database_creation_statement must contain at least one statement")
+		}
+
+		checks = []check{
+			{"creation_statement", r.CreationStatement, clusterCreationTemplateVars},
+			{"revocation_statement", r.RevocationStatement, clusterRevocationTemplateVars},
+			{"renew_statement", r.RenewStatement, clusterRenewTemplateVars},
+			{"database_creation_statement", r.DatabaseCreationStatement, databaseTemplateVars},
+			{"database_revocation_statement", r.DatabaseRevocationStatement, databaseTemplateVars},
+		}
+	}
+
+	for _, c := range checks {
+		if err := validateStatements(c.statements, c.vars); err != nil {
+			return fmt.Errorf("invalid %s: %s", c.name, err)
 		}
 	}
 
 	return nil
 }
 
+var (
+	templateVarRe = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+	dollarQuoteRe = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+)
+
+// validateStatements checks that the statements only use the template
+// variables that are available to them and are lexically well formed.
+func validateStatements(statements []string, vars []string) error {
+	for idx, stmt := range statements {
+		for _, m := range templateVarRe.FindAllStringSubmatch(stmt, -1) {
+			if !strutil.StrListContains(vars, m[1]) {
+				return fmt.Errorf("statement [%d] uses unknown variable %q, available variables are %s", idx, m[0], strings.Join(vars, ", "))
+			}
+		}
+
+		if err := checkStatementSyntax(stmt); err != nil {
+			return fmt.Errorf("statement [%d] %s", idx, err)
+		}
+	}
+
+	return nil
+}
+
+// checkStatementSyntax performs a lexical check on the statement. It catches
+// unterminated quotes, comments and unbalanced parentheses, it does not
+// validate the statement grammar.
+func checkStatementSyntax(stmt string) error {
+	depth := 0
+	for i := 0; i < len(stmt); i++ {
+		switch {
+		case stmt[i] == '\'' || stmt[i] == '"':
+			end := strings.IndexByte(stmt[i+1:], stmt[i])
+			if end < 0 {
+				return fmt.Errorf("has an unterminated quoted string")
+			}
+			i += end + 1
+		case strings.HasPrefix(stmt[i:], "--"):
+			end := strings.IndexByte(stmt[i:], '\n')
+			if end < 0 {
+				i = len(stmt)
+			} else {
+				i += end
+			}
+		case strings.HasPrefix(stmt[i:], "/*"):
+			end := strings.Index(stmt[i+2:], "*/")
+			if end < 0 {
+				return fmt.Errorf("has an unterminated comment")
+			}
+			i += end + 3
+		case stmt[i] == '$':
+			tag := dollarQuoteRe.FindString(stmt[i:])
+			if tag == "" {
+				continue
+			}
+			end := strings.Index(stmt[i+len(tag):], tag)
+			if end < 0 {
+				return fmt.Errorf("has an unterminated dollar quoted string")
+			}
+			i += len(tag) + end + len(tag) - 1
+		case stmt[i] == '(':
+			depth += 1
+		case stmt[i] == ')':
+			depth -= 1
+			if depth < 0 {
+				return fmt.Errorf("has unbalanced parentheses")
+			}
+		}
+	}
+
+	if depth != 0 {
+		return fmt.Errorf("has unbalanced parentheses")
+	}
+
+	return nil
+}
+
+func isEmptyStatement(statements []string) bool {
+	for _, s := range statements {
+		if strings.TrimSpace(s) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (b *backend) pathRoleUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	name := data.Get("name").(string)
 
-	c := &RoleConfig{}
-	err := c.loadFromFields(data)
+	c, err := loadRoleEntry(ctx, req.Storage, name)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	create := err == ErrNotFound
+	if create {
+		c = &RoleConfig{}
+	}
+
+	err = c.loadFromFields(data, create)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp, err := checkRootExecutionAllowed(ctx, req.Storage, name, c)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	err = storeRoleVersion(ctx, req.Storage, name, c)
 	if err != nil {
 		return nil, err
 	}
 
-	err = storeRoleEntry(ctx, req.Storage, name, c)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version": c.Version,
+		},
+	}, nil
+}
+
+// storeRoleVersion stores the role as a new version and retains the
+// previous revisions up to maxRoleVersions.
+func storeRoleVersion(ctx context.Context, storage logical.Storage, roleName string, role *RoleConfig) error {
+	role.Version += 1
+
+	vEntry, err := logical.StorageEntryJSON(PathRoleVersion.For(roleName, strconv.Itoa(role.Version)), role)
+	if err != nil {
+		return err
+	}
+
+	err = storage.Put(ctx, vEntry)
+	if err != nil {
+		return err
+	}
+
+	err = storeRoleEntry(ctx, storage, roleName, role)
+	if err != nil {
+		return err
+	}
+
+	versions, err := listRoleVersions(ctx, storage, roleName)
+	if err != nil {
+		return err
+	}
+
+	for len(versions) > maxRoleVersions {
+		err = storage.Delete(ctx, PathRoleVersion.For(roleName, strconv.Itoa(versions[0])))
+		if err != nil {
+			return err
+		}
+
+		versions = versions[1:]
+	}
+
+	return nil
+}
+
+func loadRoleVersion(ctx context.Context, storage logical.Storage, roleName string, version int) (*RoleConfig, error) {
+	entry, err := storage.Get(ctx, PathRoleVersion.For(roleName, strconv.Itoa(version)))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+
+	conf := &RoleConfig{}
+	err = entry.DecodeJSON(conf)
 	if err != nil {
 		return nil, err
 	}
 
-	return &logical.Response{}, nil
+	return conf, nil
+}
+
+// listRoleVersions returns the retained versions of role in ascending order
+func listRoleVersions(ctx context.Context, storage logical.Storage, roleName string) ([]int, error) {
+	entries, err := storage.List(ctx, PathRoleVersion.For(roleName, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(entries))
+	for _, e := range entries {
+		v, err := strconv.Atoi(e)
+		if err != nil {
+			continue
+		}
+
+		versions = append(versions, v)
+	}
+
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func (b *backend) pathRoleVersionsList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	versions, err := listRoleVersions(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(versions))
+	for _, v := range versions {
+		keys = append(keys, strconv.Itoa(v))
+	}
+
+	return logical.ListResponse(keys), nil
+}
+
+func (b *backend) pathRoleVersionRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	version := data.Get("version").(int)
+
+	c, err := loadRoleVersion(ctx, req.Storage, name, version)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Version %d of role %s is not available", version, name)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: c.AsMap(),
+	}, nil
+}
+
+func (b *backend) pathRoleRollback(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	version := data.Get("version").(int)
+
+	current, err := loadRoleEntry(ctx, req.Storage, name)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s is not configured", name)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := loadRoleVersion(ctx, req.Storage, name, version)
+	if err == ErrNotFound {
+		return logical.ErrorResponse(fmt.Sprintf("Version %d of role %s is not available", version, name)), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Older versions may not satisfy the validation of later releases
+	if err := c.validate(); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("Version %d of role %s cannot be restored. %s", version, name, err)), nil
+	}
+
+	resp, err := checkRootExecutionAllowed(ctx, req.Storage, name, c)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	// Rollback creates a new version with the content of an older one
+	c.Version = current.Version
+	err = storeRoleVersion(ctx, req.Storage, name, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version": c.Version,
+		},
+	}, nil
+}
+
+// checkRootExecutionAllowed returns an error response if the role executes
+// as root but is not listed in the root_execution_roles of the mount
+func checkRootExecutionAllowed(ctx context.Context, storage logical.Storage, name string, c *RoleConfig) (*logical.Response, error) {
+	if c.GetConnType() != connTypeRoot {
+		return nil, nil
+	}
+
+	mc, err := loadMountConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if !mc.CanExecuteAsRoot(name) {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s is not allowed to execute as root, add it to root_execution_roles in config first", name)), nil
+	}
+
+	return nil, nil
 }
 
 func storeRoleEntry(ctx context.Context, storage logical.Storage, roleName string, role *RoleConfig) error {
@@ -116,8 +773,41 @@ func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *
 }
 
 func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	err := req.Storage.Delete(ctx, PathRole.For(data.Get("name").(string)))
-	return nil, err
+	name := data.Get("name").(string)
+
+	leases, err := listLeaseEntries(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(leases) > 0 && !data.Get("force").(bool) {
+		return logical.ErrorResponse(fmt.Sprintf("Role %s has %d outstanding leases. Use force=true to delete it anyway", name, len(leases))), nil
+	}
+
+	err = req.Storage.Delete(ctx, PathRole.For(name))
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := listRoleVersions(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range versions {
+		err = req.Storage.Delete(ctx, PathRoleVersion.For(name, strconv.Itoa(v)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(leases) > 0 {
+		resp := &logical.Response{}
+		resp.AddWarning(fmt.Sprintf("Role %s was deleted with %d outstanding leases. The leases will be revoked using the statements captured at issue time", name, len(leases)))
+		return resp, nil
+	}
+
+	return nil, nil
 }
 
 func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
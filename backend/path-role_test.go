@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"fmt"
 	logicaltest "github.com/hashicorp/vault/helper/testhelpers/logical"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -25,11 +26,529 @@ func TestAccRole_basic(t *testing.T) {
 			testAccWriteRoleConfig(t, "roles/test-acc-two", roleAttr, false),
 			testAccReadRoleConfig(t, "roles/test-acc", roleAttr, nil, false),
 			testAccDeleteRoleConfig(t, "roles/test-acc", false),
+			testAccWriteRoleConfig(t, "roles/test-acc-invalid", map[string]interface{}{"default_ttl": 900, "max_ttl": 600}, true),
 			testAccListRolesConfig(t, "roles", []string{"test-acc-one", "test-acc-two"}),
 		},
 	})
 }
 
+func TestAccRole_renewStatement(t *testing.T) {
+	backend := testGetBackend(t)
+	customRenew := []string{
+		"alter role {{user}} valid until '{{expiration}}'",
+		"alter role {{user}} connection limit 5",
+	}
+
+	logicaltest.Test(t, logicaltest.TestCase{
+		LogicalBackend: backend,
+		Steps: []logicaltest.TestStep{
+			testAccWriteRoleConfig(t, "roles/test-default", map[string]interface{}{}, false),
+			testAccReadRoleConfig(t, "roles/test-default", map[string]interface{}{"renew_statement": defaultRenewSQL}, nil, false),
+			testAccWriteRoleConfig(t, "roles/test-custom", map[string]interface{}{"renew_statement": customRenew}, false),
+			testAccReadRoleConfig(t, "roles/test-custom", map[string]interface{}{"renew_statement": customRenew}, nil, false),
+		},
+	})
+}
+
+func TestAccRole_versions(t *testing.T) {
+	backend := testGetBackend(t)
+	creation := []string{"create role {{user}} with login password '{{password}}' valid until '{{expiration}}'"}
+
+	logicaltest.Test(t, logicaltest.TestCase{
+		LogicalBackend: backend,
+		Steps: []logicaltest.TestStep{
+			testAccWriteRoleConfig(t, "roles/test-ver", map[string]interface{}{"default_ttl": 300, "creation_statement": creation}, false),
+
+			// Partial update keeps the other attributes intact
+			testAccWriteRoleConfig(t, "roles/test-ver", map[string]interface{}{"default_ttl": 600}, false),
+			testAccReadRoleConfig(t, "roles/test-ver", map[string]interface{}{
+				"default_ttl":          600,
+				"creation_statement":   creation,
+				"revocation_statement": defaultRevocationSQL,
+				"version":              2,
+			}, nil, false),
+
+			testAccListRolesConfig(t, "roles/test-ver/versions", []string{"1", "2"}),
+			testAccReadRoleConfig(t, "roles/test-ver/versions/1", map[string]interface{}{"default_ttl": 300, "version": 1}, nil, false),
+			testAccReadRoleConfig(t, "roles/test-ver/versions/5", nil, nil, true),
+
+			// Rollback creates a new version with old content
+			testAccWriteRoleConfig(t, "roles/test-ver/rollback", map[string]interface{}{"version": 1}, false),
+			testAccReadRoleConfig(t, "roles/test-ver", map[string]interface{}{"default_ttl": 300, "version": 3}, nil, false),
+
+			testAccDeleteRoleConfig(t, "roles/test-ver", false),
+			testAccReadRoleConfig(t, "roles/test-ver/versions/1", nil, nil, true),
+		},
+	})
+}
+
+func TestAccRole_dryRun(t *testing.T) {
+	backend := testGetBackend(t)
+	cleanup, attr := prepareTestContainer(t)
+	defer cleanup()
+
+	broken := map[string]interface{}{
+		"creation_statement": append(defaultCreationSQL, "grant select on missing_table to {{user}}"),
+	}
+
+	// The user is not a member of the objects owner, so it cannot connect
+	// to a database created by vault
+	noConnect := map[string]interface{}{
+		"creation_statement": "create role {{user}} with login password '{{password}}' valid until '{{expiration}}' role {{group}}",
+	}
+
+	logicaltest.Test(t, logicaltest.TestCase{
+		LogicalBackend: backend,
+		Steps: []logicaltest.TestStep{
+			testAccWriteClusterConfig(t, "cluster/test-dry-run", attr, false),
+			testAccWriteDbConfig(t, "cluster/test-dry-run/test-db"),
+			testAccWriteRoleConfig(t, "roles/test-valid", map[string]interface{}{}, false),
+			testAccWriteRoleConfig(t, "roles/test-broken", broken, false),
+			testAccWriteRoleConfig(t, "roles/test-no-connect", noConnect, false),
+			testAccRoleDryRun(t, "test-valid", "rollback", true),
+			testAccRoleDryRun(t, "test-valid", "commit", true),
+			testAccRoleDryRun(t, "test-broken", "rollback", false),
+			testAccRoleDryRun(t, "test-no-connect", "rollback", false),
+		},
+	})
+}
+
+func testAccRoleDryRun(t *testing.T, role, mode string, expectSuccess bool) logicaltest.TestStep {
+	return logicaltest.TestStep{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/" + role + "/test",
+		Data: map[string]interface{}{
+			"cluster":  "test-dry-run",
+			"database": "test-db",
+			"mode":     mode,
+		},
+		ErrorOk: false,
+		Check: func(resp *logical.Response) error {
+			if resp.Data["success"] != expectSuccess {
+				return fmt.Errorf("expected success to be %t, got response %+v", expectSuccess, resp.Data)
+			}
+
+			return nil
+		},
+	}
+}
+
+func TestRoleConfigValidate(t *testing.T) {
+	valid := func() *RoleConfig {
+		return &RoleConfig{
+			DefaultTTL:          300,
+			MaxTTL:              600,
+			CreationStatement:   defaultCreationSQL,
+			RevocationStatement: defaultRevocationSQL,
+			RenewStatement:      defaultRenewSQL,
+		}
+	}
+
+	cases := []struct {
+		name   string
+		modify func(r *RoleConfig)
+		valid  bool
+	}{
+		{"defaults", func(r *RoleConfig) {}, true},
+		{"no max ttl", func(r *RoleConfig) { r.MaxTTL = 0 }, true},
+		{"default above max", func(r *RoleConfig) { r.DefaultTTL = 900 }, false},
+		{"negative ttl", func(r *RoleConfig) { r.DefaultTTL = -1 }, false},
+		{"empty creation", func(r *RoleConfig) { r.CreationStatement = []string{" "} }, false},
+		{"empty revocation", func(r *RoleConfig) { r.RevocationStatement = nil }, false},
+		{"unknown variable", func(r *RoleConfig) { r.CreationStatement = []string{"create role {{usr}}"} }, false},
+		{"password on revoke", func(r *RoleConfig) { r.RevocationStatement = []string{"alter role {{user}} password '{{password}}'"} }, false},
+		{"unterminated quote", func(r *RoleConfig) {
+			r.CreationStatement = []string{"create role {{user}} valid until '{{expiration}}"}
+		}, false},
+		{"unbalanced parens", func(r *RoleConfig) { r.CreationStatement = []string{"select (1"} }, false},
+		{"dollar quoted", func(r *RoleConfig) { r.CreationStatement = []string{"do $body$ begin perform ')'; end $body$"} }, true},
+		{"comment", func(r *RoleConfig) { r.CreationStatement = []string{"select 1 -- it's fine"} }, true},
+		{"cluster scoped", func(r *RoleConfig) {
+			r.Databases = []string{"one", "two"}
+			r.CreationStatement = defaultClusterCreationSQL
+			r.RevocationStatement = defaultClusterRevocationSQL
+			r.DatabaseCreationStatement = defaultDatabaseCreationSQL
+			r.DatabaseRevocationStatement = defaultDatabaseRevocationSQL
+		}, true},
+		{"cluster scoped with database variable", func(r *RoleConfig) {
+			r.DatabaseSelector = "env=prod"
+			r.DatabaseCreationStatement = defaultDatabaseCreationSQL
+		}, false},
+		{"cluster scoped without grants", func(r *RoleConfig) {
+			r.DatabaseSelector = "env=prod"
+			r.CreationStatement = defaultClusterCreationSQL
+			r.RevocationStatement = defaultClusterRevocationSQL
+		}, false},
+		{"future types", func(r *RoleConfig) {
+			r.Privileges = []RolePrivilege{{Schemas: []string{"app"}, ObjectTypes: []string{"types"}, Privileges: []string{"USAGE"}, IncludeFuture: true}}
+		}, true},
+		{"existing types", func(r *RoleConfig) {
+			r.Privileges = []RolePrivilege{{Schemas: []string{"app"}, ObjectTypes: []string{"types"}, Privileges: []string{"USAGE"}}}
+		}, false},
+		{"assume owner with privileges", func(r *RoleConfig) {
+			r.AssumeObjectsOwner = true
+			r.Privileges = []RolePrivilege{{Schemas: []string{"app"}, ObjectTypes: []string{"tables"}, Privileges: []string{"SELECT"}}}
+		}, false},
+		{"entity user", func(r *RoleConfig) {
+			r.UserType = userTypeEntity
+			r.LoginStatement = defaultEntityLoginSQL
+		}, true},
+		{"entity user without login", func(r *RoleConfig) { r.UserType = userTypeEntity }, false},
+		{"entity user in multiple databases", func(r *RoleConfig) {
+			r.UserType = userTypeEntity
+			r.LoginStatement = defaultEntityLoginSQL
+			r.Databases = []string{"one"}
+		}, false},
+		{"invalid user type", func(r *RoleConfig) { r.UserType = "static" }, false},
+		{"client certificate", func(r *RoleConfig) {
+			r.CredentialType = credentialTypeClientCert
+			r.CreationStatement = defaultClientCertCreationSQL
+		}, true},
+		{"client certificate with password", func(r *RoleConfig) { r.CredentialType = credentialTypeClientCert }, false},
+		{"invalid credential type", func(r *RoleConfig) { r.CredentialType = "token" }, false},
+		{"nologin", func(r *RoleConfig) { r.RevocationMode = revocationModeNologin }, true},
+		{"nologin then drop", func(r *RoleConfig) {
+			r.RevocationMode = revocationModeNologinThenDrop
+			r.RevocationRetention = 3600
+		}, true},
+		{"nologin then drop without retention", func(r *RoleConfig) { r.RevocationMode = revocationModeNologinThenDrop }, false},
+		{"invalid revocation mode", func(r *RoleConfig) { r.RevocationMode = "disable" }, false},
+		{"entity user disabled", func(r *RoleConfig) {
+			r.UserType = userTypeEntity
+			r.LoginStatement = defaultEntityLoginSQL
+			r.RevocationMode = revocationModeNologin
+		}, false},
+		{"invalid selector", func(r *RoleConfig) {
+			r.DatabaseSelector = "env in (prod"
+			r.CreationStatement = defaultClusterCreationSQL
+			r.RevocationStatement = defaultClusterRevocationSQL
+			r.DatabaseCreationStatement = defaultDatabaseCreationSQL
+		}, false},
+	}
+
+	for _, c := range cases {
+		r := valid()
+		c.modify(r)
+		err := r.validate()
+		if c.valid && err != nil {
+			t.Errorf("%s: expected role to be valid, got %s", c.name, err)
+		}
+
+		if !c.valid && err == nil {
+			t.Errorf("%s: expected role to be invalid", c.name)
+		}
+	}
+}
+
+func TestRoleAssumeObjectsOwner(t *testing.T) {
+	role := &RoleConfig{
+		CreationStatement:         defaultCreationSQL,
+		DatabaseCreationStatement: defaultDatabaseCreationSQL,
+	}
+
+	if !reflect.DeepEqual(role.GetCreationStatement(), defaultCreationSQL) {
+		t.Fatalf("expected creation statement to be unchanged, got %v", role.GetCreationStatement())
+	}
+
+	role.AssumeObjectsOwner = true
+	expect := append(append([]string{}, defaultCreationSQL...), queryAssumeObjectsOwner)
+	if !reflect.DeepEqual(role.GetCreationStatement(), expect) {
+		t.Fatalf("expected %v, got %v", expect, role.GetCreationStatement())
+	}
+
+	if len(role.CreationStatement) != len(defaultCreationSQL) {
+		t.Fatalf("expected role creation statement to not be modified")
+	}
+
+	role.Databases = []string{"one"}
+	expect = append(append([]string{}, defaultDatabaseCreationSQL...), queryAssumeObjectsOwner)
+	if !reflect.DeepEqual(role.GetDatabaseCreationStatement(), expect) {
+		t.Fatalf("expected %v, got %v", expect, role.GetDatabaseCreationStatement())
+	}
+
+	if !reflect.DeepEqual(role.GetCreationStatement(), defaultCreationSQL) {
+		t.Fatalf("expected objects owner to be assumed in databases only, got %v", role.GetCreationStatement())
+	}
+}
+
+func TestRoleUpdate_scopeDefaults(t *testing.T) {
+	backend := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	steps := []struct {
+		data       map[string]interface{}
+		creation   []string
+		revocation []string
+		dbCreation []string
+	}{
+		{
+			data:       map[string]interface{}{},
+			creation:   defaultCreationSQL,
+			revocation: defaultRevocationSQL,
+		},
+		{
+			data:       map[string]interface{}{"databases": "one,two"},
+			creation:   defaultClusterCreationSQL,
+			revocation: defaultClusterRevocationSQL,
+			dbCreation: defaultDatabaseCreationSQL,
+		},
+		{
+			data:       map[string]interface{}{"database_selector": "env=prod"},
+			creation:   defaultClusterCreationSQL,
+			revocation: defaultClusterRevocationSQL,
+			dbCreation: defaultDatabaseCreationSQL,
+		},
+		{
+			data:       map[string]interface{}{"databases": "", "database_selector": ""},
+			creation:   defaultCreationSQL,
+			revocation: defaultRevocationSQL,
+		},
+	}
+
+	for i, step := range steps {
+		resp, err := backend.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + testRole,
+			Storage:   storage,
+			Data:      step.data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("step %d: failed to write role. err: %v, resp: %v", i, err, resp)
+		}
+
+		role, err := loadRoleEntry(ctx, storage, testRole)
+		if err != nil {
+			t.Fatalf("step %d: failed to load role. %s", i, err)
+		}
+
+		if !reflect.DeepEqual(step.creation, role.CreationStatement) ||
+			!reflect.DeepEqual(step.revocation, role.RevocationStatement) ||
+			len(step.dbCreation) != len(role.DatabaseCreationStatement) {
+			t.Fatalf("step %d: unexpected statements %+v", i, role)
+		}
+	}
+}
+
+func TestRoleDelete_outstandingLeases(t *testing.T) {
+	backend := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	resp, err := backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/test-leased",
+		Storage:   storage,
+		Data:      map[string]interface{}{},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write role. err: %v, resp: %v", err, resp)
+	}
+
+	err = storeLeaseEntry(ctx, storage, "test-leased", "lease-one", &LeaseEntry{Username: "user-one"})
+	if err != nil {
+		t.Fatalf("failed to store lease entry. %s", err)
+	}
+
+	resp, err = backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roles/test-leased",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on delete. %s", err)
+	}
+
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected delete to be refused while leases are outstanding, got %v", resp)
+	}
+
+	resp, err = backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roles/test-leased",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"force": true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("expected forced delete to succeed. err: %v, resp: %v", err, resp)
+	}
+
+	if _, err = loadRoleEntry(ctx, storage, "test-leased"); err != ErrNotFound {
+		t.Fatalf("expected role to be deleted, got %v", err)
+	}
+}
+
+func TestRoleExecuteAs_rootAllowlist(t *testing.T) {
+	backend := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	write := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := backend.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on write to %s. %s", path, err)
+		}
+
+		return resp
+	}
+
+	resp := write("roles/test-root", map[string]interface{}{"execute_as": "superuser"})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected invalid execute_as to be rejected, got %v", resp)
+	}
+
+	resp = write("roles/test-root", map[string]interface{}{"execute_as": "root"})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected root execution to be rejected for role not in allowlist, got %v", resp)
+	}
+
+	resp = write("config", map[string]interface{}{"root_execution_roles": "test-root"})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to write config. %v", resp.Error())
+	}
+
+	resp = write("roles/test-root", map[string]interface{}{"execute_as": "root"})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("expected root execution to be allowed for role in allowlist, got %v", resp.Error())
+	}
+
+	role, err := loadRoleEntry(ctx, storage, "test-root")
+	if err != nil {
+		t.Fatalf("failed to load role. %s", err)
+	}
+
+	if role.GetConnType() != connTypeRoot {
+		t.Fatalf("expected role to execute as root, got %s", role.GetConnType())
+	}
+
+	// Rollback cannot restore root execution after the role is removed from the allowlist
+	resp = write("roles/test-root", map[string]interface{}{"execute_as": "management"})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to write role. %v", resp.Error())
+	}
+
+	resp = write("config", map[string]interface{}{"root_execution_roles": ""})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to write config. %v", resp.Error())
+	}
+
+	resp = write("roles/test-root/rollback", map[string]interface{}{"version": role.Version})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected rollback to root execution to be rejected for role not in allowlist, got %v", resp)
+	}
+
+	role, err = loadRoleEntry(ctx, storage, "test-root")
+	if err != nil {
+		t.Fatalf("failed to load role. %s", err)
+	}
+
+	if role.GetConnType() != connTypeMgmt {
+		t.Fatalf("expected role to execute as management, got %s", role.GetConnType())
+	}
+}
+
+func TestRolePrivileges(t *testing.T) {
+	backend := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	privileges := []interface{}{
+		map[string]interface{}{
+			"schemas":        []interface{}{"app"},
+			"object_types":   []interface{}{"tables", "sequences"},
+			"privileges":     []interface{}{"select"},
+			"include_future": true,
+		},
+	}
+
+	write := func(data map[string]interface{}) *logical.Response {
+		resp, err := backend.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/test-privileges",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on write. %s", err)
+		}
+
+		return resp
+	}
+
+	resp := write(map[string]interface{}{
+		"privileges":         privileges,
+		"creation_statement": []string{"create role {{user}}"},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected privileges with explicit statements to be rejected, got %v", resp)
+	}
+
+	resp = write(map[string]interface{}{
+		"privileges": []interface{}{
+			map[string]interface{}{
+				"schemas":      []interface{}{"app"},
+				"object_types": []interface{}{"functions"},
+				"privileges":   []interface{}{"select"},
+			},
+		},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected invalid privilege to be rejected, got %v", resp)
+	}
+
+	resp = write(map[string]interface{}{"privileges": privileges})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to write role. %s", resp.Error())
+	}
+
+	role, err := loadRoleEntry(ctx, storage, "test-privileges")
+	if err != nil {
+		t.Fatalf("failed to load role. %s", err)
+	}
+
+	expectCreation := []string{
+		"create role {{user}} with login password '{{password}}' valid until '{{expiration}}' role {{group}}",
+		"grant connect on database {{database}} to {{user}}",
+		"set role {{objects_owner}}",
+		`grant usage on schema "app" to {{user}}`,
+		`grant SELECT on all tables in schema "app" to {{user}}`,
+		`alter default privileges for role {{objects_owner}} in schema "app" grant SELECT on tables to {{user}}`,
+		`grant SELECT on all sequences in schema "app" to {{user}}`,
+		`alter default privileges for role {{objects_owner}} in schema "app" grant SELECT on sequences to {{user}}`,
+		"reset role",
+	}
+
+	expectRevocation := []string{
+		"set role {{objects_owner}}",
+		`alter default privileges for role {{objects_owner}} in schema "app" revoke SELECT on sequences from {{user}}`,
+		`revoke SELECT on all sequences in schema "app" from {{user}}`,
+		`alter default privileges for role {{objects_owner}} in schema "app" revoke SELECT on tables from {{user}}`,
+		`revoke SELECT on all tables in schema "app" from {{user}}`,
+		`revoke usage on schema "app" from {{user}}`,
+		"reset role",
+		"revoke connect on database {{database}} from {{user}}",
+		"set role {{user}}",
+		"drop owned by {{user}}",
+		"reset role",
+		"drop role if exists {{user}}",
+	}
+
+	if !reflect.DeepEqual(role.CreationStatement, expectCreation) {
+		t.Errorf("expected creation statement %#v, got %#v", expectCreation, role.CreationStatement)
+	}
+
+	if !reflect.DeepEqual(role.RevocationStatement, expectRevocation) {
+		t.Errorf("expected revocation statement %#v, got %#v", expectRevocation, role.RevocationStatement)
+	}
+}
+
 func testAccListRolesConfig(t *testing.T, target string, expect []string) logicaltest.TestStep {
 	return logicaltest.TestStep{
 		Operation: logical.ListOperation,
@@ -120,3 +639,63 @@ func testAccDeleteRoleConfig(t *testing.T, target string, expectError bool) logi
 		},
 	}
 }
+
+func TestRoleRollback_validation(t *testing.T) {
+	backend := testGetBackend(t)
+	storage := &logical.InmemStorage{}
+	ctx := context.Background()
+
+	write := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := backend.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on write to %s. %s", path, err)
+		}
+
+		return resp
+	}
+
+	resp := write("roles/test-rollback", map[string]interface{}{"default_ttl": 300})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to write role. %v", resp.Error())
+	}
+
+	resp = write("roles/test-rollback", map[string]interface{}{"default_ttl": 600})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to write role. %v", resp.Error())
+	}
+
+	// A version written by an older release that is not valid anymore
+	old, err := loadRoleVersion(ctx, storage, "test-rollback", 1)
+	if err != nil {
+		t.Fatalf("failed to load role version. %s", err)
+	}
+
+	old.CreationStatement = []string{"create role {{usr}}"}
+	entry, err := logical.StorageEntryJSON(PathRoleVersion.For("test-rollback", "1"), old)
+	if err != nil {
+		t.Fatalf("failed to encode role version. %s", err)
+	}
+
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatalf("failed to store role version. %s", err)
+	}
+
+	resp = write("roles/test-rollback/rollback", map[string]interface{}{"version": 1})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected rollback to an invalid version to be rejected, got %v", resp)
+	}
+
+	role, err := loadRoleEntry(ctx, storage, "test-rollback")
+	if err != nil {
+		t.Fatalf("failed to load role. %s", err)
+	}
+
+	if role.Version != 2 || role.DefaultTTL != 600 {
+		t.Fatalf("expected role to be unchanged, got version %d with default_ttl %d", role.Version, role.DefaultTTL)
+	}
+}
@@ -0,0 +1,173 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/lib/pq"
+	"github.com/mitchellh/mapstructure"
+)
+
+// RolePrivilege describes the privileges granted to the users of a role on
+// the objects in a set of schemas. It is compiled into the statements of role.
+type RolePrivilege struct {
+	Schemas       []string `json:"schemas" mapstructure:"schemas"`
+	ObjectTypes   []string `json:"object_types" mapstructure:"object_types"`
+	Privileges    []string `json:"privileges" mapstructure:"privileges"`
+	IncludeFuture bool     `json:"include_future" mapstructure:"include_future"`
+}
+
+// Privileges that can be granted on each object type
+var objectTypePrivileges = map[string][]string{
+	"tables":    {"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER", "ALL"},
+	"sequences": {"SELECT", "UPDATE", "USAGE", "ALL"},
+	"functions": {"EXECUTE", "ALL"},
+	"types":     {"USAGE", "ALL"},
+}
+
+func (p *RolePrivilege) AsMap() map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":        p.Schemas,
+		"object_types":   p.ObjectTypes,
+		"privileges":     p.Privileges,
+		"include_future": p.IncludeFuture,
+	}
+}
+
+func (p *RolePrivilege) validate() error {
+	if len(p.Schemas) == 0 {
+		return fmt.Errorf("at least one schema is required")
+	}
+
+	if len(p.ObjectTypes) == 0 {
+		return fmt.Errorf("at least one object type is required")
+	}
+
+	if len(p.Privileges) == 0 {
+		return fmt.Errorf("at least one privilege is required")
+	}
+
+	for _, s := range p.Schemas {
+		if strings.TrimSpace(s) == "" {
+			return fmt.Errorf("schema name cannot be empty")
+		}
+	}
+
+	for _, t := range p.ObjectTypes {
+		allowed, ok := objectTypePrivileges[t]
+		if !ok {
+			return fmt.Errorf("invalid object type %q, valid options are 'tables', 'sequences', 'functions' or 'types'", t)
+		}
+
+		if t == "types" && !p.IncludeFuture {
+			return fmt.Errorf("privileges on types can only be granted on future objects, include_future must be set")
+		}
+
+		for _, priv := range p.Privileges {
+			if !strutil.StrListContains(allowed, priv) {
+				return fmt.Errorf("privilege %s cannot be granted on %s, valid options are %s", priv, t, strings.Join(allowed, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// parsePrivileges decodes the privileges supplied in request and
+// normalizes the object types and privileges.
+func parsePrivileges(raw []interface{}) ([]RolePrivilege, error) {
+	var privileges []RolePrivilege
+	if err := mapstructure.WeakDecode(raw, &privileges); err != nil {
+		return nil, fmt.Errorf("invalid privileges: %s", err)
+	}
+
+	for i := range privileges {
+		p := &privileges[i]
+		for j := range p.ObjectTypes {
+			p.ObjectTypes[j] = strings.ToLower(strings.TrimSpace(p.ObjectTypes[j]))
+		}
+
+		for j := range p.Privileges {
+			p.Privileges[j] = strings.ToUpper(strings.TrimSpace(p.Privileges[j]))
+		}
+	}
+
+	return privileges, nil
+}
+
+// compilePrivileges returns the statements that grant the privileges to a user
+// in a database, and the statements that revoke them. The revocation statements
+// are the inverse of the grants in reverse order.
+func compilePrivileges(privileges []RolePrivilege) (grant []string, revoke []string) {
+	for _, p := range privileges {
+		privs := strings.Join(p.Privileges, ", ")
+		for _, s := range p.Schemas {
+			schema := pq.QuoteIdentifier(s)
+
+			grant = append(grant, fmt.Sprintf("grant usage on schema %s to {{user}}", schema))
+			revoke = append(revoke, fmt.Sprintf("revoke usage on schema %s from {{user}}", schema))
+
+			for _, t := range p.ObjectTypes {
+				if t != "types" {
+					grant = append(grant, fmt.Sprintf("grant %s on all %s in schema %s to {{user}}", privs, t, schema))
+					revoke = append(revoke, fmt.Sprintf("revoke %s on all %s in schema %s from {{user}}", privs, t, schema))
+				}
+
+				if p.IncludeFuture {
+					grant = append(grant, fmt.Sprintf("alter default privileges for role {{objects_owner}} in schema %s grant %s on %s to {{user}}", schema, privs, t))
+					revoke = append(revoke, fmt.Sprintf("alter default privileges for role {{objects_owner}} in schema %s revoke %s on %s from {{user}}", schema, privs, t))
+				}
+			}
+		}
+	}
+
+	for i, j := 0, len(revoke)-1; i < j; i, j = i+1, j-1 {
+		revoke[i], revoke[j] = revoke[j], revoke[i]
+	}
+
+	return grant, revoke
+}
+
+// applyPrivileges replaces the statements of role with the statements
+// compiled from its privileges. The users of a role that spans multiple
+// databases are created in the maintenance database and granted the
+// privileges in every database.
+//
+// The management role does not inherit the privileges of the objects owner,
+// so the grants and revokes are executed after assuming the objects owner.
+// Privileges that are left on the user, for example the ones granted by the
+// user itself, are dropped before the user is removed.
+func (r *RoleConfig) applyPrivileges() {
+	grant, revoke := compilePrivileges(r.Privileges)
+
+	grant = append([]string{"grant connect on database {{database}} to {{user}}", "set role {{objects_owner}}"}, grant...)
+	grant = append(grant, "reset role")
+
+	revoke = append([]string{"set role {{objects_owner}}"}, revoke...)
+	revoke = append(revoke,
+		"reset role",
+		"revoke connect on database {{database}} from {{user}}",
+		"set role {{user}}",
+		"drop owned by {{user}}",
+		"reset role",
+	)
+
+	if r.IsClusterScoped() {
+		r.CreationStatement = defaultClusterCreationSQL
+		r.RevocationStatement = defaultClusterRevocationSQL
+		r.DatabaseCreationStatement = grant
+		r.DatabaseRevocationStatement = revoke
+		return
+	}
+
+	create := "create role {{user}} with login password '{{password}}' valid until '{{expiration}}' role {{group}}"
+	if r.IsClientCertificate() {
+		create = "create role {{user}} with login role {{group}}"
+	}
+
+	r.CreationStatement = append([]string{create}, grant...)
+	r.RevocationStatement = append(revoke, "drop role if exists {{user}}")
+	r.DatabaseCreationStatement = nil
+	r.DatabaseRevocationStatement = nil
+}
@@ -0,0 +1,281 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+// selector matches the metadata of an object. It is written as a comma
+// separated list of requirements that must all be satisfied, similar to
+// the label selectors of Kubernetes:
+//
+//	key=value, key==value   the key exists and has the value
+//	key!=value              the key does not exist or has another value
+//	key in (v1, v2)         the key exists and has one of the values
+//	key notin (v1, v2)      the key does not exist or has none of the values
+//	key                     the key exists
+//	!key                    the key does not exist
+//
+// An empty selector matches nothing.
+type selector []selectorRequirement
+
+type selectorOperator string
+
+const (
+	selectorEquals       selectorOperator = "="
+	selectorNotEquals    selectorOperator = "!="
+	selectorIn           selectorOperator = "in"
+	selectorNotIn        selectorOperator = "notin"
+	selectorExists       selectorOperator = "exists"
+	selectorDoesNotExist selectorOperator = "!"
+)
+
+type selectorRequirement struct {
+	key      string
+	operator selectorOperator
+	values   []string
+}
+
+func (r selectorRequirement) Matches(data map[string]string) bool {
+	v, ok := data[r.key]
+	switch r.operator {
+	case selectorEquals:
+		return ok && v == r.values[0]
+	case selectorNotEquals:
+		return !ok || v != r.values[0]
+	case selectorIn:
+		return ok && strutil.StrListContains(r.values, v)
+	case selectorNotIn:
+		return !ok || !strutil.StrListContains(r.values, v)
+	case selectorExists:
+		return ok
+	case selectorDoesNotExist:
+		return !ok
+	}
+
+	return false
+}
+
+func (r selectorRequirement) String() string {
+	switch r.operator {
+	case selectorExists:
+		return r.key
+	case selectorDoesNotExist:
+		return "!" + r.key
+	case selectorIn, selectorNotIn:
+		return fmt.Sprintf("%s %s (%s)", r.key, r.operator, strings.Join(r.values, ", "))
+	}
+
+	return r.key + string(r.operator) + r.values[0]
+}
+
+func parseSelector(s string) (selector, error) {
+	p := &selectorParser{tokens: lexSelector(s)}
+
+	var sel selector
+	for !p.done() {
+		r, err := p.requirement()
+		if err != nil {
+			return nil, err
+		}
+
+		sel = append(sel, r)
+		if p.done() {
+			break
+		}
+
+		if tok := p.next(); tok != "," {
+			return nil, fmt.Errorf("expected ',' after requirement %q, found %q", r.String(), tok)
+		}
+	}
+
+	return sel, nil
+}
+
+// selectorFromMap returns a selector that requires every key to have its value
+func selectorFromMap(data map[string]string) selector {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sel := make(selector, 0, len(keys))
+	for _, k := range keys {
+		sel = append(sel, selectorRequirement{key: k, operator: selectorEquals, values: []string{data[k]}})
+	}
+
+	return sel
+}
+
+func (s selector) Empty() bool {
+	return len(s) == 0
+}
+
+func (s selector) Matches(data map[string]string) bool {
+	if s.Empty() {
+		return false
+	}
+
+	for _, r := range s {
+		if !r.Matches(data) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s selector) String() string {
+	parts := make([]string, 0, len(s))
+	for _, r := range s {
+		parts = append(parts, r.String())
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// lexSelector splits the selector into words and the operator characters
+func lexSelector(s string) []string {
+	var tokens []string
+	var word strings.Builder
+
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			flush()
+		case c == '!' && i+1 < len(runes) && runes[i+1] == '=':
+			flush()
+			tokens = append(tokens, "!=")
+			i++
+		case c == '=' && i+1 < len(runes) && runes[i+1] == '=':
+			flush()
+			tokens = append(tokens, "==")
+			i++
+		case strings.ContainsRune(",()=!", c):
+			flush()
+			tokens = append(tokens, string(c))
+		default:
+			word.WriteRune(c)
+		}
+	}
+
+	flush()
+	return tokens
+}
+
+type selectorParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *selectorParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *selectorParser) peek() string {
+	if p.done() {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *selectorParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func isSelectorWord(tok string) bool {
+	switch tok {
+	case "", ",", "(", ")", "=", "==", "!=", "!":
+		return false
+	}
+
+	return true
+}
+
+func (p *selectorParser) requirement() (selectorRequirement, error) {
+	if p.peek() == "!" {
+		p.next()
+		key := p.next()
+		if !isSelectorWord(key) {
+			return selectorRequirement{}, fmt.Errorf("expected a key after '!', found %q", key)
+		}
+
+		return selectorRequirement{key: key, operator: selectorDoesNotExist}, nil
+	}
+
+	key := p.next()
+	if !isSelectorWord(key) {
+		return selectorRequirement{}, fmt.Errorf("expected a key, found %q", key)
+	}
+
+	switch op := p.peek(); op {
+	case "", ",":
+		return selectorRequirement{key: key, operator: selectorExists}, nil
+	case "=", "==", "!=":
+		p.next()
+
+		// An empty value is allowed and matches keys set to an empty string
+		value := ""
+		if isSelectorWord(p.peek()) {
+			value = p.next()
+		}
+
+		operator := selectorEquals
+		if op == "!=" {
+			operator = selectorNotEquals
+		}
+
+		return selectorRequirement{key: key, operator: operator, values: []string{value}}, nil
+	case "in", "notin":
+		p.next()
+		values, err := p.values()
+		if err != nil {
+			return selectorRequirement{}, fmt.Errorf("invalid values for key %q: %s", key, err)
+		}
+
+		return selectorRequirement{key: key, operator: selectorOperator(op), values: values}, nil
+	default:
+		return selectorRequirement{}, fmt.Errorf("unexpected %q after key %q, expected one of '=', '!=', 'in' or 'notin'", op, key)
+	}
+}
+
+func (p *selectorParser) values() ([]string, error) {
+	if tok := p.next(); tok != "(" {
+		return nil, fmt.Errorf("expected '(', found %q", tok)
+	}
+
+	var values []string
+	for {
+		tok := p.next()
+		if !isSelectorWord(tok) {
+			return nil, fmt.Errorf("expected a value, found %q", tok)
+		}
+		values = append(values, tok)
+
+		switch tok := p.next(); tok {
+		case ",":
+			continue
+		case ")":
+			return values, nil
+		default:
+			return nil, fmt.Errorf("expected ',' or ')', found %q", tok)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	data := map[string]string{
+		"env":  "prod",
+		"team": "payments",
+	}
+
+	cases := []struct {
+		selector string
+		match    bool
+		err      bool
+	}{
+		{"env=prod", true, false},
+		{"env=prod, team=payments", true, false},
+		{"env=prod,team=search", false, false},
+		{"region=eu", false, false},
+		{"", false, false},
+		{"env", true, false},
+		{"!env", false, false},
+		{"!region, team", true, false},
+		{"env!=prod", false, false},
+		{"region!=eu", true, false},
+		{"env==prod", true, false},
+		{"env in (staging, prod)", true, false},
+		{"env notin (staging,prod)", false, false},
+		{"region notin (eu)", true, false},
+		{"region in (eu, us)", false, false},
+		{"env notin (staging), team in (payments), !deprecated", true, false},
+		{"=prod", false, true},
+		{"env in staging", false, true},
+		{"env in (staging", false, true},
+		{"env in ()", false, true},
+		{"env prod", false, true},
+		{"!", false, true},
+	}
+
+	for _, c := range cases {
+		sel, err := parseSelector(c.selector)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected parse error", c.selector)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected parse error %s", c.selector, err)
+			continue
+		}
+
+		if got := sel.Matches(data); got != c.match {
+			t.Errorf("%q: expected match %t, got %t", c.selector, c.match, got)
+		}
+	}
+}